@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Backend is a byte-oriented TTL store. The in-memory backend is the
+// default; anything with Redis-like get/set-with-expiry/delete semantics
+// (such as repositories.NewCacheRepository) can be plugged in instead.
+type Backend interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+type entry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type memory struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+func NewMemory() Backend {
+	m := &memory{entries: map[string]entry{}}
+	go m.sweep(time.Minute)
+	return m
+}
+
+func (m *memory) Get(key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(e.expiresAt) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (m *memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = entry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memory) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		m.mu.Lock()
+		for key, e := range m.entries {
+			if now.After(e.expiresAt) {
+				delete(m.entries, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/conversation"
+	"github.com/tzuhsitseng/kamiq-bot/flex"
+	"github.com/tzuhsitseng/kamiq-bot/geo"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const (
+	catcherFlowName    = "catcher"
+	defaultSelfIntro   = "我愛蛇哥"
+	catcherPlateKey    = "plate"
+	catcherRegionKey   = "region"
+	catcherCityKey     = "city"
+	catcherDistrictKey = "district"
+	catcherLatKey      = "lat"
+	catcherLngKey      = "lng"
+	catcherIntroKey    = "intro"
+	catcherCoverKey    = "cover"
+	catcherIntroLimit  = 50
+
+	// regionFromLocation marks a region answered by sharing a location.
+	regionFromLocation = "location"
+)
+
+var flows *conversation.Engine
+
+func registerFlows() {
+	flows = conversation.NewEngine(bot, uploadImage)
+	flows.Register(catcherFlow())
+	flows.Register(verificationFlow())
+	flows.Register(maintenanceFlow())
+}
+
+func uploadImage(messageID string) (string, error) {
+	resp, err := bot.GetMessageContent(messageID).Do()
+	if err != nil {
+		return "", err
+	}
+	coverURL := uploadImgur(resp.Content)
+	log.Println(fmt.Sprintf("image url: %s", coverURL))
+	return coverURL, nil
+}
+
+// previousCatcher is the user's saved data, offered as "use previous value".
+func previousCatcher(s *conversation.Session) *repositories.Catcher {
+	previous, _ := s.Data.(*repositories.Catcher)
+	return previous
+}
+
+func validateLicensePlateNumber(_ *conversation.Session, input string) (string, error) {
+	if !newLicensePlateNumberRegexp.MatchString(input) && !oldLicensePlateNumberRegexp.MatchString(input) {
+		return "", conversation.ErrInvalid(i18n.T(i18n.Default, "catcher.plate_invalid"))
+	}
+	return strings.ToUpper(input), nil
+}
+
+func catcherFlow() *conversation.Flow {
+	return &conversation.Flow{
+		Name:      catcherFlowName,
+		Lang:      i18n.Default,
+		AckPrefix: i18n.T(i18n.Default, "catcher.ack"),
+		Steps: []conversation.Step{
+			{
+				Key:      catcherPlateKey,
+				Prompt:   i18n.T(i18n.Default, "catcher.plate_prompt"),
+				Validate: validateLicensePlateNumber,
+				Previous: func(s *conversation.Session) string {
+					if previous := previousCatcher(s); previous != nil {
+						return previous.LicensePlateNumber
+					}
+					return ""
+				},
+			},
+			{
+				Key:     catcherRegionKey,
+				Prompt:  i18n.T(i18n.Default, "catcher.region_prompt"),
+				Options: func(_ *conversation.Session) []string { return geo.Regions },
+				QuickReplies: []*linebot.QuickReplyButton{
+					linebot.NewQuickReplyButton("", linebot.NewLocationAction(i18n.T(i18n.Default, "nearby.share_button"))),
+				},
+				Optional: true,
+				Location: catcherLocation,
+				Previous: func(s *conversation.Session) string {
+					if previous := previousCatcher(s); previous != nil {
+						return previous.HauntedPlaces
+					}
+					return ""
+				},
+			},
+			{
+				Key:    catcherCityKey,
+				Prompt: i18n.T(i18n.Default, "catcher.city_prompt"),
+				Options: func(s *conversation.Session) []string {
+					names := make([]string, 0)
+					for _, county := range geo.CountiesIn(s.Get(catcherRegionKey)) {
+						names = append(names, county.Name)
+					}
+					return names
+				},
+				Skip: placesAnswered,
+			},
+			{
+				Key:      catcherDistrictKey,
+				Prompt:   i18n.T(i18n.Default, "catcher.district_prompt"),
+				Optional: true,
+				Validate: func(_ *conversation.Session, input string) (string, error) {
+					if !geo.ValidDistrict(input) {
+						return "", conversation.ErrInvalid(i18n.T(i18n.Default, "catcher.district_invalid"))
+					}
+					return geo.Normalize(input), nil
+				},
+				Skip: placesAnswered,
+			},
+			{
+				Key:       catcherIntroKey,
+				Prompt:    i18n.T(i18n.Default, "catcher.intro_prompt", catcherIntroLimit, defaultSelfIntro),
+				Optional:  true,
+				SkipValue: defaultSelfIntro,
+				Validate: func(_ *conversation.Session, input string) (string, error) {
+					if utf8.RuneCountInString(input) > catcherIntroLimit {
+						return "", conversation.ErrInvalid(i18n.T(i18n.Default, "catcher.intro_too_long", catcherIntroLimit))
+					}
+					if input == "52~~" {
+						return defaultSelfIntro, nil
+					}
+					return input, nil
+				},
+				Previous: func(s *conversation.Session) string {
+					if previous := previousCatcher(s); previous != nil {
+						return previous.SelfIntro
+					}
+					return ""
+				},
+			},
+			{
+				Key:    catcherCoverKey,
+				Prompt: i18n.T(i18n.Default, "catcher.cover_prompt"),
+				Input:  conversation.InputImage,
+				QuickReplies: []*linebot.QuickReplyButton{
+					linebot.NewQuickReplyButton("", linebot.NewCameraRollAction(i18n.T(i18n.Default, "catcher.choose_photo"))),
+					linebot.NewQuickReplyButton("", linebot.NewCameraAction(i18n.T(i18n.Default, "catcher.take_photo"))),
+				},
+				Previous: func(s *conversation.Session) string {
+					if previous := previousCatcher(s); previous != nil {
+						return previous.CoverURL
+					}
+					return ""
+				},
+				PreviousLabel: i18n.T(i18n.Default, "catcher.current_photo"),
+			},
+		},
+		Summary: func(s *conversation.Session) []linebot.SendingMessage {
+			preview := catcherFromSession(s)
+			preview.GroupName = i18n.T(i18n.Default, "catcher.group_placeholder")
+			messages, _ := flex.Messages(i18n.T(i18n.Default, "catcher.confirm_alt"), makeCatcherContents(i18n.Default, []repositories.Catcher{preview}), 1, false)
+			return messages
+		},
+		Complete:    saveCatcher,
+		Restart:     beginCatcherFlow,
+		CancelText:  i18n.T(i18n.Default, "catcher.cancelled"),
+		TimeoutText: i18n.T(i18n.Default, "catcher.timeout"),
+	}
+}
+
+// startCatcherFlow begins registration, or asks whether to resume when a
+// registration is already in progress.
+func startCatcherFlow(replyToken, userID string) {
+	if s, ok := flows.Active(userID); ok && s.Flow.Name == catcherFlowName {
+		flows.Start(replyToken, userID, catcherFlowName, s.Data)
+		return
+	}
+	beginCatcherFlow(replyToken, userID)
+}
+
+func beginCatcherFlow(replyToken, userID string) {
+	authorized := false
+	for gid := range regionalGroups() {
+		if _, err := groupMemberProfile(gid, userID); err == nil {
+			authorized = true
+			break
+		}
+	}
+	if !authorized {
+		replyText(replyToken, i18n.T(i18n.Default, "catcher.unauthorized"))
+		return
+	}
+
+	var previous *repositories.Catcher
+	if rows, err := catcherRepo.ListByUser(userID); err != nil {
+		log.Println(err)
+	} else if len(rows) > 0 {
+		previous = &rows[0]
+	}
+	flows.StartWithPrefix(replyToken, userID, catcherFlowName, previous, i18n.T(i18n.Default, "catcher.authorized"))
+}
+
+// catcherLocation answers the region step from a shared location, keeping
+// only the district and coarsened coordinates.
+func catcherLocation(s *conversation.Session, location *linebot.LocationMessage) (string, error) {
+	city, district := geo.ParseAddress(location.Address)
+	if city == "" {
+		return "", conversation.ErrInvalid(i18n.T(i18n.Default, "catcher.location_unknown"))
+	}
+	lat, lng := geo.Coarsen(location.Latitude, location.Longitude)
+	s.Set(catcherCityKey, city)
+	s.Set(catcherDistrictKey, district)
+	s.Set(catcherLatKey, strconv.FormatFloat(lat, 'f', 2, 64))
+	s.Set(catcherLngKey, strconv.FormatFloat(lng, 'f', 2, 64))
+	return regionFromLocation, nil
+}
+
+// placesAnswered skips the county and district steps once a location was
+// shared or the previous places were reused.
+func placesAnswered(s *conversation.Session) bool {
+	region := s.Get(catcherRegionKey)
+	for _, r := range geo.Regions {
+		if region == r {
+			return false
+		}
+	}
+	return true
+}
+
+func catcherFromSession(s *conversation.Session) repositories.Catcher {
+	catcher := repositories.Catcher{
+		LicensePlateNumber: s.Get(catcherPlateKey),
+		UserID:             s.UserID,
+		SelfIntro:          s.Get(catcherIntroKey),
+		CoverURL:           s.Get(catcherCoverKey),
+	}
+	previous := previousCatcher(s)
+	if previous != nil {
+		catcher.UserName = previous.UserName
+	}
+
+	switch region := s.Get(catcherRegionKey); {
+	case region == "":
+	case region == regionFromLocation:
+		catcher.City = s.Get(catcherCityKey)
+		catcher.District = s.Get(catcherDistrictKey)
+		if lat, err := strconv.ParseFloat(s.Get(catcherLatKey), 64); err == nil {
+			catcher.Latitude = &lat
+		}
+		if lng, err := strconv.ParseFloat(s.Get(catcherLngKey), 64); err == nil {
+			catcher.Longitude = &lng
+		}
+	case placesAnswered(s):
+		if previous != nil {
+			catcher.HauntedPlaces = previous.HauntedPlaces
+			catcher.City = previous.City
+			catcher.District = previous.District
+			catcher.Latitude = previous.Latitude
+			catcher.Longitude = previous.Longitude
+		}
+	default:
+		catcher.City = s.Get(catcherCityKey)
+		catcher.District = s.Get(catcherDistrictKey)
+	}
+	if catcher.City != "" {
+		catcher.HauntedPlaces = catcher.City + catcher.District
+	}
+	return catcher
+}
+
+func saveCatcher(replyToken string, s *conversation.Session) {
+	catcher := catcherFromSession(s)
+
+	finalCatchers := make([]repositories.Catcher, 0)
+	for groupID, groupName := range regionalGroups() {
+		if profile, err := groupMemberProfile(groupID, catcher.UserID); err == nil {
+			catcher.UserName = profile.DisplayName
+			catcher.GroupID = groupID
+			catcher.GroupName = groupName
+			finalCatchers = append(finalCatchers, catcher)
+		}
+	}
+	if len(finalCatchers) == 0 {
+		replyText(replyToken, i18n.T(i18n.Default, "catcher.unauthorized"))
+		return
+	}
+
+	for idx := range finalCatchers {
+		finalCatchers[idx].UserName = catcher.UserName
+		if _, err := catcherRepo.Create(finalCatchers[idx]); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	replyCarousel(replyToken, "", i18n.T(i18n.Default, "card.alt"), makeCatcherContents(i18n.Default, finalCatchers),
+		linebot.NewTextMessage(i18n.T(i18n.Default, "catcher.saved")))
+	linkRichMenu(catcher.UserID)
+}
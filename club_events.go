@@ -0,0 +1,385 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/flex"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const (
+	defaultEventReminderLead = 24 * time.Hour
+	eventReminderInterval    = time.Minute
+	// multicastLimit is the most recipients one LINE multicast accepts.
+	multicastLimit = 500
+)
+
+var eventRepo repositories.EventsRepository
+
+// clubLocation is the time zone times are typed and shown in. Taiwan has no
+// daylight saving, so a fixed zone avoids depending on tzdata.
+var clubLocation = time.FixedZone("Asia/Taipei", 8*60*60)
+
+var clubTimeLayouts = []string{"2006/01/02 15:04", "2006-01-02 15:04", "2006/1/2 15:04", "2006-1-2 15:04"}
+
+// shortTimeLayouts leave out the year, meaning the next such date.
+var shortTimeLayouts = []string{"01/02 15:04", "1/2 15:04"}
+
+func parseClubTime(text string) (time.Time, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	for _, layout := range clubTimeLayouts {
+		if t, err := time.ParseInLocation(layout, text, clubLocation); err == nil {
+			return t, nil
+		}
+	}
+	now := time.Now().In(clubLocation)
+	for _, layout := range shortTimeLayouts {
+		if t, err := time.ParseInLocation(layout, text, clubLocation); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.Before(now) {
+				t = t.AddDate(1, 0, 0)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", text)
+}
+
+func formatClubTime(t time.Time) string {
+	return t.In(clubLocation).Format("2006/01/02 15:04")
+}
+
+// deadlineRegexp matches a trailing "/ 截止 <time>" clause. It is picked off
+// before the rest is split on slashes or bars, since the time has slashes too.
+var deadlineRegexp = regexp.MustCompile(`[/|｜\n]\s*(?:截止|deadline)[:：]?\s*(.+)$`)
+
+// cutDeadline splits a trailing "截止 <time>" clause off text, returning the
+// text before it, the time as typed, and whether there was one.
+func cutDeadline(text string) (before, deadline string, found bool) {
+	m := deadlineRegexp.FindStringSubmatchIndex(text)
+	if m == nil {
+		return text, "", false
+	}
+	return text[:m[0]], text[m[2]:m[3]], true
+}
+
+// splitFields splits "a | b | c" style arguments, also accepting
+// full-width bars and one field per line.
+func splitFields(text string) []string {
+	text = strings.NewReplacer("｜", "|", "\n", "|").Replace(text)
+	parts := strings.Split(text, "|")
+	result := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// eventCommand manages the group's meetups:
+// "活動 [列表]", "活動 新增 名稱 | 時間 | 地點 | 人數", "活動 參加/退出/名單/刪除 編號".
+func eventCommand(ctx *commandContext) {
+	sub, rest := ctx.Args, ""
+	if idx := strings.IndexAny(ctx.Args, " \n"); idx >= 0 {
+		sub, rest = ctx.Args[:idx], strings.TrimSpace(ctx.Args[idx+1:])
+	}
+
+	switch sub {
+	case "", "列表":
+		listEvents(ctx)
+	case "新增":
+		createEvent(ctx, rest)
+	case "參加", "退出", "名單", "刪除":
+		event, ok := findEvent(ctx.Event.ReplyToken, ctx.Lang, ctx.GroupID, rest)
+		if !ok {
+			return
+		}
+		switch sub {
+		case "參加":
+			joinEvent(ctx.Event.ReplyToken, ctx.Lang, ctx.UserID, event)
+		case "退出":
+			leaveEvent(ctx.Event.ReplyToken, ctx.Lang, ctx.UserID, event)
+		case "名單":
+			replyAttendees(ctx.Event.ReplyToken, ctx.Lang, event)
+		case "刪除":
+			deleteEvent(ctx, event)
+		}
+	default:
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "event.usage"))
+	}
+}
+
+func listEvents(ctx *commandContext) {
+	events, err := eventRepo.ListUpcoming(ctx.GroupID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(events) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "event.none"))
+		return
+	}
+	cards := make([]flex.Card, 0, len(events))
+	for _, event := range events {
+		cards = append(cards, makeEventCard(ctx.Lang, event))
+	}
+	replyCarousel(ctx.Event.ReplyToken, ctx.GroupID, i18n.T(ctx.Lang, "event.upcoming"), cards)
+}
+
+func createEvent(ctx *commandContext, args string) {
+	fields := splitFields(args)
+	if len(fields) < 3 || len(fields) > 4 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "event.create_usage"))
+		return
+	}
+
+	startsAt, err := parseClubTime(fields[1])
+	if err != nil {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "event.invalid_time", fields[1]))
+		return
+	}
+	if startsAt.Before(time.Now()) {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "event.past_time"))
+		return
+	}
+	capacity := 0
+	if len(fields) == 4 {
+		if capacity, err = strconv.Atoi(fields[3]); err != nil || capacity < 0 {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "event.invalid_capacity"))
+			return
+		}
+	}
+
+	event := repositories.Event{
+		GroupID:   ctx.GroupID,
+		Title:     fields[0],
+		StartsAt:  startsAt,
+		Place:     fields[2],
+		Capacity:  capacity,
+		CreatorID: ctx.UserID,
+	}
+	if event.ID, err = eventRepo.Create(event); err != nil {
+		log.Println(err)
+		return
+	}
+	replyCarousel(ctx.Event.ReplyToken, ctx.GroupID, event.Title, []flex.Card{makeEventCard(ctx.Lang, event)},
+		linebot.NewTextMessage(i18n.T(ctx.Lang, "event.created", event.ID)))
+}
+
+// findEvent looks up an event by the number typed or carried in a
+// postback, only within the group it belongs to.
+func findEvent(replyToken, lang, groupID, idText string) (repositories.Event, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(idText, "#"))
+	if err != nil {
+		replyText(replyToken, i18n.T(lang, "event.usage"))
+		return repositories.Event{}, false
+	}
+	event, err := eventRepo.Get(id)
+	if err != nil || event.GroupID != groupID {
+		if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			log.Println(err)
+		}
+		replyText(replyToken, i18n.T(lang, "event.not_found", idText))
+		return repositories.Event{}, false
+	}
+	return event, true
+}
+
+func attendeeCount(event repositories.Event) string {
+	count, err := eventRepo.CountAttendees(event.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	if event.Capacity > 0 {
+		return fmt.Sprintf("%d / %d", count, event.Capacity)
+	}
+	return strconv.Itoa(count)
+}
+
+func makeEventCard(lang string, event repositories.Event) flex.Card {
+	data := func(op string) string {
+		return fmt.Sprintf("action=event&id=%d&op=%s", event.ID, op)
+	}
+	when := formatClubTime(event.StartsAt)
+	return flex.Card{
+		Bubble: flex.Bubble(nil,
+			flex.Box(
+				flex.Title(event.Title),
+				flex.Row(i18n.T(lang, "event.time"), when),
+				flex.Row(i18n.T(lang, "event.place"), event.Place),
+				flex.Row(i18n.T(lang, "event.attendees"), attendeeCount(event)),
+				flex.Text(i18n.T(lang, "event.hint", event.ID)),
+			),
+			flex.Buttons(
+				linebot.NewPostbackAction(i18n.T(lang, "event.join"), data("join"), "", ""),
+				linebot.NewPostbackAction(i18n.T(lang, "event.leave"), data("leave"), "", ""),
+				linebot.NewPostbackAction(i18n.T(lang, "event.list"), data("list"), "", ""),
+			),
+		),
+		Alt: fmt.Sprintf("%s %s %s", event.Title, when, event.Place),
+	}
+}
+
+func displayName(groupID, userID string) string {
+	if profile, err := groupMemberProfile(groupID, userID); err == nil {
+		return profile.DisplayName
+	}
+	return userID
+}
+
+func joinEvent(replyToken, lang, userID string, event repositories.Event) {
+	if !event.StartsAt.After(time.Now()) {
+		replyText(replyToken, i18n.T(lang, "event.started", event.Title))
+		return
+	}
+	name := displayName(event.GroupID, userID)
+	err := eventRepo.Join(repositories.EventRSVP{EventID: event.ID, UserID: userID, UserName: name})
+	switch {
+	case errors.Is(err, repositories.ErrEventFull):
+		replyText(replyToken, i18n.T(lang, "event.full", event.Title))
+	case err != nil:
+		log.Println(err)
+	default:
+		replyText(replyToken, i18n.T(lang, "event.joined", name, event.Title, attendeeCount(event)))
+	}
+}
+
+func leaveEvent(replyToken, lang, userID string, event repositories.Event) {
+	name := displayName(event.GroupID, userID)
+	left, err := eventRepo.Leave(event.ID, userID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !left {
+		replyText(replyToken, i18n.T(lang, "event.not_joined", name, event.Title))
+		return
+	}
+	replyText(replyToken, i18n.T(lang, "event.left", name, event.Title, attendeeCount(event)))
+}
+
+func replyAttendees(replyToken, lang string, event repositories.Event) {
+	attendees, err := eventRepo.ListAttendees(event.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(attendees) == 0 {
+		replyText(replyToken, i18n.T(lang, "event.no_attendees", event.Title))
+		return
+	}
+	lines := []string{i18n.T(lang, "event.attendee_list", event.Title, len(attendees))}
+	for idx, attendee := range attendees {
+		lines = append(lines, fmt.Sprintf("%d. %s", idx+1, attendee.UserName))
+	}
+	replyText(replyToken, strings.Join(lines, "\n"))
+}
+
+// deleteEvent is open to the event's creator and to admins.
+func deleteEvent(ctx *commandContext, event repositories.Event) {
+	if event.CreatorID != ctx.UserID && !hasRole(ctx.UserID, ctx.GroupID, repositories.RoleAdmin) {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "event.delete_denied"))
+		return
+	}
+	if err := eventRepo.Delete(event.ID); err != nil {
+		log.Println(err)
+		return
+	}
+	replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "event.deleted", event.Title))
+}
+
+// handleEventPostback handles the buttons on event cards, which only work
+// in the group the event was created in.
+func handleEventPostback(event *linebot.Event, values url.Values) {
+	groupID := event.Source.GroupID
+	lang := languageOf(groupID)
+	found, ok := findEvent(event.ReplyToken, lang, groupID, values.Get("id"))
+	if !ok {
+		return
+	}
+	switch values.Get("op") {
+	case "join":
+		joinEvent(event.ReplyToken, lang, event.Source.UserID, found)
+	case "leave":
+		leaveEvent(event.ReplyToken, lang, event.Source.UserID, found)
+	case "list":
+		replyAttendees(event.ReplyToken, lang, found)
+	}
+}
+
+// startEventReminders pushes a reminder to each attendee once an event is
+// within EVENT_REMINDER_LEAD (default 24h) of starting. Pushes only reach
+// attendees who have added the bot as a friend.
+func startEventReminders() {
+	lead := defaultEventReminderLead
+	if v, err := time.ParseDuration(os.Getenv("EVENT_REMINDER_LEAD")); err == nil && v > 0 {
+		lead = v
+	}
+
+	go func() {
+		for {
+			remindEvents(lead)
+			time.Sleep(eventReminderInterval)
+		}
+	}()
+}
+
+func remindEvents(lead time.Duration) {
+	events, err := eventRepo.ListDueReminders(time.Now().Add(lead))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, event := range events {
+		attendees, err := eventRepo.ListAttendees(event.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		// Left unmarked, the event is checked again next round, so people
+		// who sign up late are still reminded.
+		if len(attendees) == 0 {
+			continue
+		}
+		userIDs := make([]string, 0, len(attendees))
+		for _, attendee := range attendees {
+			userIDs = append(userIDs, attendee.UserID)
+		}
+		message := linebot.NewTextMessage(i18n.T(languageOf(event.GroupID), "event.reminder",
+			event.Title, formatClubTime(event.StartsAt), event.Place))
+		if err := multicast(userIDs, message); err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if err := eventRepo.MarkReminded(event.ID); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// multicast pushes messages to any number of users in batches LINE accepts,
+// stopping at the first batch that fails.
+func multicast(userIDs []string, messages ...linebot.SendingMessage) error {
+	for start := 0; start < len(userIDs); start += multicastLimit {
+		end := start + multicastLimit
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		if _, err := bot.Multicast(userIDs[start:end], messages...).Do(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
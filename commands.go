@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+type commandContext struct {
+	Event   *linebot.Event
+	GroupID string
+	UserID  string
+	// Text is the message as typed and Msg the same text without the
+	// leading or trailing question mark.
+	Text    string
+	Msg     string
+	Args    string
+	Mention *linebot.Mention
+	// Lang is the chat's reply language, filled in by dispatchCommand.
+	Lang string
+}
+
+type command struct {
+	Keywords  []string
+	Role      repositories.Role
+	GroupOnly bool
+	// TakesArgs lets the keyword match as the first word of a longer
+	// message; other commands, such as the FAQ, need the whole message.
+	TakesArgs bool
+	Handler   func(ctx *commandContext)
+}
+
+var commands = map[string]*command{}
+
+func registerCommand(cmd *command) {
+	for _, keyword := range cmd.Keywords {
+		commands[keyword] = cmd
+	}
+}
+
+func registerCommands() {
+	registerFAQCommands()
+
+	registerCommand(&command{
+		Keywords:  []string{"群組資訊", "群組類型", "群組名稱", "群組地區", "群組語言", "群組純文字", "群組列表"},
+		TakesArgs: true,
+		Role:      repositories.RoleAdmin,
+		GroupOnly: true,
+		Handler:   groupAdminCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"歡迎詞", "歡迎卡片"},
+		TakesArgs: true,
+		Role:      repositories.RoleAdmin,
+		GroupOnly: true,
+		Handler:   welcomeAdminCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"test welcome"},
+		Role:      repositories.RoleAdmin,
+		GroupOnly: true,
+		Handler: func(ctx *commandContext) {
+			welcome(ctx.Event.ReplyToken, ctx.GroupID, "test")
+		},
+	})
+	registerCommand(&command{
+		Keywords:  []string{"未讀規則"},
+		Role:      repositories.RoleAdmin,
+		GroupOnly: true,
+		Handler:   unreadRulesCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"附近"},
+		TakesArgs: true,
+		Role:      repositories.RoleMember,
+		Handler:   nearbyCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"活動"},
+		TakesArgs: true,
+		Role:      repositories.RoleMember,
+		GroupOnly: true,
+		Handler:   eventCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"投票"},
+		TakesArgs: true,
+		Role:      repositories.RoleMember,
+		GroupOnly: true,
+		Handler:   pollCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"團購"},
+		TakesArgs: true,
+		Role:      repositories.RoleMember,
+		GroupOnly: true,
+		Handler:   groupBuyCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"保養"},
+		TakesArgs: true,
+		Role:      repositories.RoleGuest,
+		Handler:   maintenanceCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"加油"},
+		TakesArgs: true,
+		Role:      repositories.RoleGuest,
+		Handler:   fuelCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"油耗"},
+		Role:      repositories.RoleMember,
+		GroupOnly: true,
+		Handler:   fuelStatsCommand,
+	})
+	registerCommand(&command{
+		Keywords:  []string{"角色"},
+		TakesArgs: true,
+		Role:      repositories.RoleGuest,
+		Handler:   roleCommand,
+	})
+}
+
+// isCommandText reports whether text is addressed to the bot, i.e. starts
+// or ends with a half- or full-width question mark.
+func isCommandText(text string) bool {
+	return strings.HasPrefix(text, "?") ||
+		strings.HasSuffix(text, "?") ||
+		strings.HasPrefix(text, "？") ||
+		strings.HasSuffix(text, "？")
+}
+
+func trimCommandText(text string) string {
+	msg := strings.TrimPrefix(text, "?")
+	msg = strings.TrimPrefix(msg, "？")
+	msg = strings.TrimSuffix(msg, "?")
+	msg = strings.TrimSuffix(msg, "？")
+	return msg
+}
+
+// findCommand matches the whole message first, so keywords containing
+// spaces work, then falls back to the first word for commands that take
+// arguments. Questions such as "交車 要注意什麼" are not FAQ lookups.
+func findCommand(msg string) (*command, string) {
+	if cmd, ok := commands[msg]; ok {
+		return cmd, msg
+	}
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return nil, ""
+	}
+	if cmd, ok := commands[fields[0]]; ok && cmd.TakesArgs {
+		return cmd, fields[0]
+	}
+	return nil, ""
+}
+
+// dispatchCommand runs the registered command matching ctx.Msg after
+// checking its permission, and reports whether one matched.
+func dispatchCommand(ctx *commandContext) bool {
+	cmd, keyword := findCommand(ctx.Msg)
+	if cmd == nil || (cmd.GroupOnly && ctx.GroupID == "") {
+		return false
+	}
+	ctx.Lang = languageOf(ctx.GroupID)
+	if !hasRole(ctx.UserID, ctx.GroupID, cmd.Role) {
+		replyText(ctx.Event.ReplyToken, permissionDenied(ctx.Lang, cmd.Role))
+		return true
+	}
+	ctx.Args = strings.TrimSpace(strings.TrimPrefix(ctx.Msg, keyword))
+	cmd.Handler(ctx)
+	return true
+}
@@ -0,0 +1,465 @@
+// Package conversation runs multi-step 1:1 flows such as the catcher
+// registration. A Flow declares its steps; the Engine keeps one session per
+// user and handles quick replies, back/skip/cancel, previous values,
+// confirmation and timeouts so flows only describe what to ask and what to
+// do with the answers.
+package conversation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+)
+
+type InputType int
+
+const (
+	InputText InputType = iota
+	InputImage
+)
+
+const (
+	defaultTimeout  = 30 * time.Minute
+	quickReplyLimit = 20
+)
+
+// ErrInvalid wraps messages that should be shown to the user verbatim when
+// a validator rejects an answer.
+type ErrInvalid string
+
+func (e ErrInvalid) Error() string {
+	return string(e)
+}
+
+type Step struct {
+	Key    string
+	Prompt string
+	Input  InputType
+	// Optional steps offer a skip button which stores SkipValue.
+	Optional  bool
+	SkipValue string
+	// Validate normalizes a text answer. Returning an ErrInvalid re-asks
+	// the step with that message.
+	Validate func(s *Session, input string) (string, error)
+	// Previous returns the user's earlier answer, offered as "use previous".
+	// PreviousLabel replaces the value on the button when set.
+	Previous      func(s *Session) string
+	PreviousLabel string
+	// Options are offered as quick replies and, when set, are the only
+	// accepted text answers.
+	Options func(s *Session) []string
+	// Location, when set, lets the step be answered with a shared location.
+	// It may store extra values with Session.Set.
+	Location func(s *Session, location *linebot.LocationMessage) (string, error)
+	// Skip reports whether earlier answers make this step unnecessary.
+	Skip         func(s *Session) bool
+	QuickReplies []*linebot.QuickReplyButton
+}
+
+type Flow struct {
+	Name  string
+	Steps []Step
+	// Lang selects the catalog for the engine's own prompts and buttons.
+	Lang string
+	// Timeout is the allowed idle time between answers.
+	Timeout time.Duration
+	// AckPrefix is put in front of the next prompt after a valid answer.
+	AckPrefix string
+	// Summary, when set, is shown for confirmation before Complete runs.
+	Summary  func(s *Session) []linebot.SendingMessage
+	Complete func(replyToken string, s *Session)
+	// Restart, when set, replaces the plain restart so flows can redo
+	// their own checks before calling StartWithPrefix.
+	Restart     func(replyToken, userID string)
+	CancelText  string
+	TimeoutText string
+}
+
+func (f *Flow) text(key string, args ...interface{}) string {
+	return i18n.T(f.Lang, key, args...)
+}
+
+type Session struct {
+	Flow      *Flow
+	UserID    string
+	Step      int
+	Values    map[string]string
+	Data      interface{}
+	UpdatedAt time.Time
+}
+
+func (s *Session) Get(key string) string {
+	return s.Values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	s.Values[key] = value
+}
+
+func (s *Session) confirming() bool {
+	return s.Step >= len(s.Flow.Steps)
+}
+
+func (s *Session) skipped() bool {
+	skip := s.Flow.Steps[s.Step].Skip
+	return skip != nil && skip(s)
+}
+
+// ImageUploader turns a LINE image message ID into a stored image URL.
+type ImageUploader func(messageID string) (string, error)
+
+type Engine struct {
+	bot      *linebot.Client
+	upload   ImageUploader
+	flows    map[string]*Flow
+	sessions sync.Map
+}
+
+func NewEngine(bot *linebot.Client, upload ImageUploader) *Engine {
+	e := &Engine{bot: bot, upload: upload, flows: map[string]*Flow{}}
+	go e.sweep(time.Minute)
+	return e
+}
+
+func (e *Engine) Register(flow *Flow) {
+	if flow.Timeout == 0 {
+		flow.Timeout = defaultTimeout
+	}
+	e.flows[flow.Name] = flow
+}
+
+// Active returns the user's live session for any flow.
+func (e *Engine) Active(userID string) (*Session, bool) {
+	v, ok := e.sessions.Load(userID)
+	if !ok {
+		return nil, false
+	}
+	s := v.(*Session)
+	if time.Since(s.UpdatedAt) > s.Flow.Timeout {
+		return s, false
+	}
+	return s, true
+}
+
+func (e *Engine) Cancel(userID string) {
+	e.sessions.Delete(userID)
+}
+
+// Start begins the named flow. If the user is already in the middle of the
+// same flow they are asked whether to resume or restart; any other flow is
+// replaced.
+func (e *Engine) Start(replyToken, userID, name string, data interface{}) {
+	flow, ok := e.flows[name]
+	if !ok {
+		log.Printf("conversation: unknown flow %s", name)
+		return
+	}
+	if s, ok := e.Active(userID); ok && s.Flow == flow {
+		e.reply(replyToken, linebot.NewTextMessage(flow.text("flow.resume_prompt")).
+			WithQuickReplies(linebot.NewQuickReplyItems(
+				postbackButton(flow.text("flow.resume"), flow.Name, "resume"),
+				postbackButton(flow.text("flow.restart"), flow.Name, "restart"),
+			)))
+		return
+	}
+	e.begin(replyToken, userID, flow, data, "")
+}
+
+// StartWithPrefix is Start without the resume check, prefixing the first
+// prompt, e.g. with the result of an authorization check.
+func (e *Engine) StartWithPrefix(replyToken, userID, name string, data interface{}, prefix string) {
+	if flow, ok := e.flows[name]; ok {
+		e.begin(replyToken, userID, flow, data, prefix)
+	}
+}
+
+func (e *Engine) begin(replyToken, userID string, flow *Flow, data interface{}, prefix string) {
+	s := &Session{Flow: flow, UserID: userID, Values: map[string]string{}, Data: data}
+	e.store(s)
+	e.prompt(replyToken, s, prefix)
+}
+
+// HandleText feeds a typed answer to the user's flow and reports whether
+// the user was in one.
+func (e *Engine) HandleText(replyToken, userID, text string) bool {
+	s, ok := e.session(replyToken, userID)
+	if !ok {
+		return s != nil
+	}
+	if text == s.Flow.text("flow.cancel") || text == i18n.T(i18n.Default, "flow.cancel") {
+		e.cancel(replyToken, s)
+		return true
+	}
+	if s.confirming() {
+		e.prompt(replyToken, s, "")
+		return true
+	}
+
+	step := s.Flow.Steps[s.Step]
+	if step.Input != InputText {
+		e.prompt(replyToken, s, "")
+		return true
+	}
+
+	value := text
+	if step.Options != nil && !contains(step.Options(s), text) {
+		e.prompt(replyToken, s, s.Flow.text("flow.choose_option"))
+		return true
+	}
+	if step.Validate != nil {
+		var err error
+		if value, err = step.Validate(s, text); err != nil {
+			var invalid ErrInvalid
+			if errors.As(err, &invalid) {
+				e.prompt(replyToken, s, string(invalid))
+			} else {
+				log.Println(err)
+			}
+			return true
+		}
+	}
+	e.advance(replyToken, s, step.Key, value)
+	return true
+}
+
+// HandleImage feeds an image to the user's flow and reports whether the
+// current step was waiting for one.
+func (e *Engine) HandleImage(replyToken, userID, messageID string) bool {
+	s, ok := e.session(replyToken, userID)
+	if !ok {
+		return s != nil
+	}
+	if s.confirming() || s.Flow.Steps[s.Step].Input != InputImage {
+		return false
+	}
+
+	url, err := e.upload(messageID)
+	if err != nil || url == "" {
+		log.Println(err)
+		e.prompt(replyToken, s, s.Flow.text("flow.upload_failed"))
+		return true
+	}
+	e.advance(replyToken, s, s.Flow.Steps[s.Step].Key, url)
+	return true
+}
+
+// HandleLocation feeds a shared location to the user's flow and reports
+// whether the current step accepts one.
+func (e *Engine) HandleLocation(replyToken, userID string, location *linebot.LocationMessage) bool {
+	s, ok := e.session(replyToken, userID)
+	if !ok {
+		return s != nil
+	}
+	if s.confirming() || s.Flow.Steps[s.Step].Location == nil {
+		return false
+	}
+
+	step := s.Flow.Steps[s.Step]
+	value, err := step.Location(s, location)
+	if err != nil {
+		var invalid ErrInvalid
+		if errors.As(err, &invalid) {
+			e.prompt(replyToken, s, string(invalid))
+		} else {
+			log.Println(err)
+		}
+		return true
+	}
+	e.advance(replyToken, s, step.Key, value)
+	return true
+}
+
+// HandlePostback handles "action=flow" postbacks from the buttons the
+// engine attaches to its prompts.
+func (e *Engine) HandlePostback(replyToken, userID string, values url.Values) {
+	flow, ok := e.flows[values.Get("flow")]
+	if !ok {
+		return
+	}
+	op := values.Get("op")
+
+	s, active := e.Active(userID)
+	if op == "restart" {
+		e.sessions.Delete(userID)
+		if flow.Restart != nil {
+			flow.Restart(replyToken, userID)
+		} else {
+			e.begin(replyToken, userID, flow, nil, "")
+		}
+		return
+	}
+	if !active || s.Flow != flow {
+		e.reply(replyToken, linebot.NewTextMessage(flow.text("flow.ended")))
+		return
+	}
+
+	switch op {
+	case "resume":
+		e.prompt(replyToken, s, "")
+	case "cancel":
+		e.cancel(replyToken, s)
+	case "back":
+		for s.Step > 0 {
+			s.Step--
+			if !s.skipped() {
+				break
+			}
+		}
+		e.store(s)
+		e.prompt(replyToken, s, "")
+	case "skip":
+		if s.confirming() || !s.Flow.Steps[s.Step].Optional {
+			e.prompt(replyToken, s, s.Flow.text("flow.not_skippable"))
+			return
+		}
+		step := s.Flow.Steps[s.Step]
+		e.advance(replyToken, s, step.Key, step.SkipValue)
+	case "previous":
+		if s.confirming() || s.Flow.Steps[s.Step].Previous == nil {
+			e.prompt(replyToken, s, "")
+			return
+		}
+		step := s.Flow.Steps[s.Step]
+		e.advance(replyToken, s, step.Key, step.Previous(s))
+	case "confirm":
+		if !s.confirming() {
+			e.prompt(replyToken, s, "")
+			return
+		}
+		e.sessions.Delete(userID)
+		s.Flow.Complete(replyToken, s)
+	}
+}
+
+func (e *Engine) session(replyToken, userID string) (*Session, bool) {
+	s, ok := e.Active(userID)
+	if s != nil && !ok {
+		e.sessions.Delete(userID)
+		text := s.Flow.TimeoutText
+		if text == "" {
+			text = s.Flow.text("flow.timeout")
+		}
+		e.reply(replyToken, linebot.NewTextMessage(text))
+	}
+	return s, ok
+}
+
+func (e *Engine) advance(replyToken string, s *Session, key, value string) {
+	s.Values[key] = value
+	s.Step++
+	for !s.confirming() && s.skipped() {
+		s.Step++
+	}
+	e.store(s)
+
+	if s.confirming() && s.Flow.Summary == nil {
+		e.sessions.Delete(s.UserID)
+		s.Flow.Complete(replyToken, s)
+		return
+	}
+	e.prompt(replyToken, s, s.Flow.AckPrefix)
+}
+
+func (e *Engine) cancel(replyToken string, s *Session) {
+	e.sessions.Delete(s.UserID)
+	text := s.Flow.CancelText
+	if text == "" {
+		text = s.Flow.text("flow.cancelled")
+	}
+	e.reply(replyToken, linebot.NewTextMessage(text))
+}
+
+func (e *Engine) prompt(replyToken string, s *Session, prefix string) {
+	flow := s.Flow
+	if s.confirming() {
+		messages := flow.Summary(s)
+		messages = append(messages, linebot.NewTextMessage(flow.text("flow.confirm_prompt")).WithQuickReplies(linebot.NewQuickReplyItems(
+			postbackButton(flow.text("flow.confirm"), flow.Name, "confirm"),
+			postbackButton(flow.text("flow.back"), flow.Name, "back"),
+			postbackButton(flow.text("flow.cancel"), flow.Name, "cancel"),
+		)))
+		e.reply(replyToken, messages...)
+		return
+	}
+
+	step := flow.Steps[s.Step]
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	if step.Options != nil {
+		for _, option := range step.Options(s) {
+			buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewMessageAction(truncate(option, quickReplyLimit), option)))
+		}
+	}
+	buttons = append(buttons, step.QuickReplies...)
+	if step.Previous != nil {
+		if previous := step.Previous(s); previous != "" {
+			label := step.PreviousLabel
+			if label == "" {
+				label = previous
+			}
+			buttons = append(buttons, postbackButton(truncate(flow.text("flow.previous", label), quickReplyLimit), flow.Name, "previous"))
+		}
+	}
+	if step.Optional {
+		buttons = append(buttons, postbackButton(flow.text("flow.skip"), flow.Name, "skip"))
+	}
+	if s.Step > 0 {
+		buttons = append(buttons, postbackButton(flow.text("flow.back"), flow.Name, "back"))
+	}
+	buttons = append(buttons, postbackButton(flow.text("flow.cancel"), flow.Name, "cancel"))
+
+	text := step.Prompt
+	if prefix != "" {
+		text = flow.text("flow.prefixed", prefix, text)
+	}
+	e.reply(replyToken, linebot.NewTextMessage(text).WithQuickReplies(linebot.NewQuickReplyItems(buttons...)))
+}
+
+func (e *Engine) store(s *Session) {
+	s.UpdatedAt = time.Now()
+	e.sessions.Store(s.UserID, s)
+}
+
+func (e *Engine) reply(replyToken string, messages ...linebot.SendingMessage) {
+	if _, err := e.bot.ReplyMessage(replyToken, messages...).Do(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (e *Engine) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		e.sessions.Range(func(key, value interface{}) bool {
+			if s := value.(*Session); time.Since(s.UpdatedAt) > s.Flow.Timeout {
+				e.sessions.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+func postbackButton(label, flow, op string) *linebot.QuickReplyButton {
+	data := fmt.Sprintf("action=flow&flow=%s&op=%s", flow, op)
+	return linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, data, "", label))
+}
+
+func contains(options []string, s string) bool {
+	for _, option := range options {
+		if option == s {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return string([]rune(s)[:limit-1]) + "…"
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+var memberRepo repositories.MembersRepository
+
+func handleFollow(event *linebot.Event) {
+	log.Printf("followed by user id: %s", event.Source.UserID)
+	go linkRichMenu(event.Source.UserID)
+
+	lang := i18n.Default
+	if _, err := bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage(
+		i18n.T(lang, "events.follow"),
+	).WithQuickReplies(linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "help.button.catcher"), "一起抓抓樂")),
+	))).Do(); err != nil {
+		log.Println(err)
+	}
+}
+
+func handleUnfollow(event *linebot.Event) {
+	userID := event.Source.UserID
+	log.Printf("unfollowed by user id: %s", userID)
+
+	flows.Cancel(userID)
+}
+
+func handleJoin(event *linebot.Event) {
+	groupID := event.Source.GroupID
+	if groupID == "" {
+		return
+	}
+	log.Printf("joined group id: %s", groupID)
+
+	registerGroup(groupID)
+	if err := groupRepo.SetLeft(groupID, false); err != nil {
+		log.Println(err)
+	}
+
+	replyText(event.ReplyToken, i18n.T(languageOf(groupID), "events.join"))
+}
+
+func handleLeave(event *linebot.Event) {
+	groupID := event.Source.GroupID
+	if groupID == "" {
+		return
+	}
+	log.Printf("left group id: %s", groupID)
+
+	if err := groupRepo.SetLeft(groupID, true); err != nil {
+		log.Println(err)
+	}
+}
+
+func handleMemberJoined(event *linebot.Event) {
+	groupID := event.Source.GroupID
+	if groupID == "" {
+		return
+	}
+	log.Printf("group id: %s", groupID)
+
+	for _, member := range event.Members {
+		invalidateGroupMember(groupID, member.UserID)
+		if err := memberRepo.Join(groupID, member.UserID); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if !isWelcomeGroup(groupID) {
+		return
+	}
+
+	names := make([]string, 0)
+	for _, member := range event.Members {
+		userID := member.UserID
+		log.Printf("user id: %s", userID)
+		go pushQuizInvite(userID)
+		if profile, err := groupMemberProfile(groupID, userID); err != nil {
+			log.Println(err)
+		} else {
+			names = append(names, profile.DisplayName)
+		}
+	}
+
+	welcomes.Add(groupID, event.ReplyToken, names)
+}
+
+// handleMemberLeft drops the member's catcher row for the group so they no
+// longer show up in plate searches as a member there.
+func handleMemberLeft(event *linebot.Event) {
+	groupID := event.Source.GroupID
+	if groupID == "" {
+		return
+	}
+	log.Printf("group id: %s", groupID)
+
+	for _, member := range event.Members {
+		userID := member.UserID
+		log.Printf("member left, user id: %s", userID)
+		invalidateGroupMember(groupID, userID)
+		if err := memberRepo.Leave(groupID, userID); err != nil {
+			log.Println(err)
+		}
+		if err := catcherRepo.DeleteByGroupAndUser(groupID, userID); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+type FAQ struct {
+	Keywords []string
+	Actions  []linebot.TemplateAction
+}
+
+var faqs = []FAQ{
+	{
+		Keywords: []string{"指令", "常用指令"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewMessageAction("交車", "交車？"),
+			linebot.NewMessageAction("外觀", "外觀相關？"),
+			linebot.NewMessageAction("內裝", "內裝相關？"),
+			linebot.NewMessageAction("設定", "設定相關？"),
+			linebot.NewMessageAction("行車記錄器", "行車記錄器？"),
+			linebot.NewMessageAction("輪胎", "輪胎相關？"),
+			linebot.NewMessageAction("防跳石網", "防跳石網？"),
+			linebot.NewMessageAction("鑰匙皮套", "鑰匙皮套？"),
+			linebot.NewMessageAction("遮陽簾", "遮陽簾？"),
+			linebot.NewMessageAction("隔熱紙", "隔熱紙？"),
+			linebot.NewURIAction("更多 (尚未更新)", "https://drive.google.com/file/d/1AM7PAPzMhp9BT3qKEP0lMdDKEx62kRSW/view"),
+		},
+	},
+	{
+		Keywords: []string{"交車"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("交車前驗車檢查項目2.0", "https://drive.google.com/file/d/19N6rUajn42eWfQJMikYySdcyGEvr1QR4/view"),
+			linebot.NewURIAction("正式交車檢查2.0", "https://drive.google.com/file/d/1S-XPfwNZFWAwQzc3gZbOj3vM8dP7TXR4/view"),
+		},
+	},
+	{
+		Keywords: []string{"族貼", "族框"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("KAMIQ TW CLUB 族貼 | 族框", "https://kamiq.club/article?sid=350&aid=434"),
+		},
+	},
+	{
+		Keywords: []string{"外觀相關"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("水簾洞與導水條", "https://kamiq.club/article?sid=324&aid=378"),
+			linebot.NewURIAction("雨刷異音、會跳、立雨刷與更換", "https://kamiq.club/article?sid=324&aid=379"),
+			linebot.NewURIAction("後視鏡指甲倒插問題", "https://kamiq.club/article?sid=324&aid=381"),
+			linebot.NewURIAction("第三煞車燈水氣無法散去", "https://kamiq.club/article?sid=324&aid=382"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=324"),
+		},
+	},
+	{
+		Keywords: []string{"內裝相關"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("車室異音-低速篇", "https://kamiq.club/article?sid=325&aid=383"),
+			linebot.NewURIAction("車室異音-高速篇", "https://kamiq.club/article?sid=325&aid=384"),
+			linebot.NewURIAction("車室靜音工程(含DIY與外廠安裝)", "https://kamiq.club/article?sid=325&aid=386"),
+			linebot.NewURIAction("冷氣濾網更換", "https://kamiq.club/article?sid=325&aid=400"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=325"),
+		},
+	},
+	{
+		Keywords: []string{"設定相關"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("搖控器啟閉車窗示範", "https://kamiq.club/article?sid=328&aid=375"),
+			linebot.NewURIAction("Keyless鑰匙沒電手動開門方式", "https://kamiq.club/article?sid=328&aid=376"),
+			linebot.NewURIAction("怠速引擎熄火判斷條件", "https://kamiq.club/article?sid=328&aid=377"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=328"),
+		},
+	},
+	{
+		Keywords: []string{"行車記錄器"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("Garmin 66WD", "https://kamiq.club/article?sid=329&aid=394"),
+			linebot.NewURIAction("HP S970 (電子後視鏡)", "https://kamiq.club/article?sid=329&aid=395"),
+			linebot.NewURIAction("DOD RX900", "https://kamiq.club/article?sid=329&aid=503"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=328"),
+		},
+	},
+	{
+		Keywords: []string{"輪胎相關"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("胎壓偵測器", "https://kamiq.club/article?sid=334&aid=388"),
+			linebot.NewURIAction("有線/無線打氣機", "https://kamiq.club/article?sid=334&aid=456"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=334"),
+		},
+	},
+	{
+		Keywords: []string{"防跳石網"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("防跳石網安裝", "https://kamiq.club/article?sid=335&aid=402"),
+			linebot.NewURIAction("防跳石網配色參考", "https://kamiq.club/article?sid=335&aid=404"),
+			linebot.NewURIAction("怠速引擎熄火判斷條件", "https://kamiq.club/article?sid=328&aid=377"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=335"),
+		},
+	},
+	{
+		Keywords: []string{"鑰匙皮套"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("Hsu's 頑皮革", "https://kamiq.club/article?sid=338&aid=416"),
+			linebot.NewURIAction("Story Leather", "https://kamiq.club/article?sid=338&aid=425"),
+			linebot.NewURIAction("賽頓精品手工皮件", "https://kamiq.club/article?sid=338&aid=423"),
+			linebot.NewURIAction("JC手作客製皮套", "https://kamiq.club/article?sid=338&aid=424"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=338"),
+		},
+	},
+	{
+		Keywords: []string{"遮陽簾"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("晴天遮陽簾", "https://kamiq.club/article?sid=330&aid=438"),
+			linebot.NewURIAction("徐府遮陽簾", "https://kamiq.club/article?sid=330&aid=439"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=330"),
+		},
+	},
+	{
+		Keywords: []string{"隔熱紙"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("GAMA-E系列", "https://kamiq.club/article?sid=330&aid=403"),
+			linebot.NewURIAction("Carlife X系列", "https://kamiq.club/article?sid=330&aid=417"),
+			linebot.NewURIAction("3M極黑系列", "https://kamiq.club/article?sid=330&aid=499"),
+			linebot.NewURIAction("Solar Gard 舒熱佳鑽石 LX 系列", "https://kamiq.club/article?sid=330&aid=500"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=330"),
+		},
+	},
+	{
+		Keywords: []string{"避光墊"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("愛力美奈納碳避光墊", "https://kamiq.club/article?sid=333&aid=427"),
+			linebot.NewURIAction("BSM專用仿麂皮避光墊", "https://kamiq.club/article?sid=333&aid=428"),
+		},
+	},
+	{
+		Keywords: []string{"晴雨窗"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("晴雨窗", "https://kamiq.club/article?sid=333&aid=445"),
+		},
+	},
+	{
+		Keywords: []string{"腳踏墊"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("3D卡固", "https://kamiq.club/article?sid=331&aid=406"),
+			linebot.NewURIAction("Škoda原廠腳踏墊", "https://kamiq.club/article?sid=331&aid=420"),
+			linebot.NewURIAction("台中裕峰訂製款", "https://kamiq.club/article?sid=331&aid=419"),
+		},
+	},
+	{
+		Keywords: []string{"後車廂墊"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("後車廂墊", "https://kamiq.club/article?sid=331&aid=430"),
+			linebot.NewURIAction("3M安美", "https://kamiq.club/article?sid=331&aid=418"),
+		},
+	},
+	{
+		Keywords: []string{"車側飾板", "後廂護板"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("車側飾板|後廂護板", "https://kamiq.club/article?sid=336"),
+		},
+	},
+	{
+		Keywords: []string{"其他週邊"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("旋轉杯架", "https://kamiq.club/article?sid=350&aid=436"),
+			linebot.NewURIAction("後行李箱連動燈", "https://kamiq.club/article?sid=350&aid=448"),
+			linebot.NewURIAction("光控燈膜", "https://kamiq.club/article?sid=350&aid=446"),
+			linebot.NewURIAction("KAMIQ TW CLUB 族貼 | 族框", "https://kamiq.club/article?sid=350&aid=434"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=350"),
+		},
+	},
+	{
+		Keywords: []string{"原廠週邊"},
+		Actions: []linebot.TemplateAction{
+			linebot.NewURIAction("原廠週邊價格表", "https://kamiq.club/article?sid=349&aid=407"),
+			linebot.NewURIAction("原廠檔泥板", "https://kamiq.club/article?sid=349&aid=444"),
+			linebot.NewURIAction("原廠門側垃圾桶", "https://kamiq.club/article?sid=349&aid=442"),
+			linebot.NewURIAction("原廠多媒體底座", "https://kamiq.club/article?sid=349&aid=443"),
+			linebot.NewURIAction("更多", "https://kamiq.club/article?sid=349"),
+		},
+	},
+}
+
+func registerFAQCommands() {
+	for _, faq := range faqs {
+		actions := faq.Actions
+		registerCommand(&command{
+			Keywords: faq.Keywords,
+			Role:     repositories.RoleGuest,
+			Handler: func(ctx *commandContext) {
+				reply(ctx.Event.ReplyToken, ctx.GroupID, ctx.Msg, actions...)
+			},
+		})
+	}
+}
@@ -0,0 +1,322 @@
+// Package flex builds the LINE Flex messages the bot sends: labelled rows,
+// image cards and carousels that are split to stay within LINE's limits.
+package flex
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const (
+	// MaxBubbles is the most bubbles LINE accepts in one carousel.
+	MaxBubbles = 12
+	// MaxMessages is the most messages one reply or push may carry.
+	MaxMessages = 5
+	// MaxCarouselSize is LINE's limit on a carousel's JSON size in bytes.
+	MaxCarouselSize = 50000
+	// MaxBubbleSize is LINE's limit on a bubble's JSON size in bytes.
+	MaxBubbleSize = 30000
+)
+
+// carouselOverhead is the JSON of an empty carousel, which wraps the
+// bubbles; each bubble after the first also needs a comma.
+var carouselOverhead = len(`{"type":"carousel","contents":[]}`)
+
+// Theme holds the colours and images shared by every card. Empty fields
+// fall back to DefaultTheme.
+type Theme struct {
+	RowIcon     string `json:"row_icon"`
+	LabelColor  string `json:"label_color"`
+	ValueColor  string `json:"value_color"`
+	BadgeColor  string `json:"badge_color"`
+	ButtonColor string `json:"button_color"`
+	// LogoURL is the hero of cards without a picture of their own, such as
+	// the FAQ links.
+	LogoURL string `json:"logo_url"`
+	// PlaceholderURL replaces missing photos, e.g. a catcher without cover.
+	PlaceholderURL string `json:"placeholder_url"`
+}
+
+var DefaultTheme = Theme{
+	RowIcon:    "https://scdn.line-apps.com/n/channel_devcenter/img/fx/review_gold_star_28.png",
+	LabelColor: "#aaaaaa",
+	ValueColor: "#666666",
+	BadgeColor: "#1DB446",
+	LogoURL:    "https://kamiq.club/upload/36/favicon_images/c1a630ef-c78f-43cc-b95e-0619f3f4da4d.jpg",
+}
+
+var theme = DefaultTheme
+
+// CurrentTheme returns the theme the builders use.
+func CurrentTheme() Theme {
+	return theme
+}
+
+// SetTheme replaces the theme; call it before serving.
+func SetTheme(t Theme) {
+	fill := func(field *string, fallback string) {
+		if *field == "" {
+			*field = fallback
+		}
+	}
+	fill(&t.RowIcon, DefaultTheme.RowIcon)
+	fill(&t.LabelColor, DefaultTheme.LabelColor)
+	fill(&t.ValueColor, DefaultTheme.ValueColor)
+	fill(&t.BadgeColor, DefaultTheme.BadgeColor)
+	fill(&t.ButtonColor, DefaultTheme.ButtonColor)
+	fill(&t.LogoURL, DefaultTheme.LogoURL)
+	fill(&t.PlaceholderURL, DefaultTheme.PlaceholderURL)
+	theme = t
+}
+
+// LoadTheme reads a JSON theme file and applies it.
+func LoadTheme(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var t Theme
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+	SetTheme(t)
+	return nil
+}
+
+// Row is a baseline "★ label: value" line as used on the catcher cards.
+func Row(label, value string) *linebot.BoxComponent {
+	labelFlex, valueFlex := 1, 2
+	return &linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeBaseline,
+		Spacing: linebot.FlexComponentSpacingTypeSm,
+		Contents: []linebot.FlexComponent{
+			&linebot.IconComponent{
+				Type: linebot.FlexComponentTypeIcon,
+				URL:  theme.RowIcon,
+			},
+			&linebot.TextComponent{
+				Type:  linebot.FlexComponentTypeText,
+				Color: theme.LabelColor,
+				Size:  linebot.FlexTextSizeTypeMd,
+				Text:  label + ":",
+				Flex:  &labelFlex,
+			},
+			&linebot.TextComponent{
+				Type:  linebot.FlexComponentTypeText,
+				Color: theme.ValueColor,
+				Size:  linebot.FlexTextSizeTypeMd,
+				Text:  nonEmpty(value),
+				Flex:  &valueFlex,
+				Wrap:  true,
+			},
+		},
+	}
+}
+
+// Title is a bold heading line.
+func Title(text string) *linebot.TextComponent {
+	return &linebot.TextComponent{
+		Type:   linebot.FlexComponentTypeText,
+		Text:   nonEmpty(text),
+		Weight: linebot.FlexTextWeightTypeBold,
+		Size:   linebot.FlexTextSizeTypeLg,
+		Wrap:   true,
+	}
+}
+
+// Text is a plain wrapped line.
+func Text(text string) *linebot.TextComponent {
+	return &linebot.TextComponent{
+		Type:  linebot.FlexComponentTypeText,
+		Text:  nonEmpty(text),
+		Color: theme.ValueColor,
+		Wrap:  true,
+	}
+}
+
+// Badge is a small bold line in the badge colour, e.g. "✔ 認證車主".
+func Badge(text string) *linebot.TextComponent {
+	return &linebot.TextComponent{
+		Type:   linebot.FlexComponentTypeText,
+		Color:  theme.BadgeColor,
+		Size:   linebot.FlexTextSizeTypeSm,
+		Weight: linebot.FlexTextWeightTypeBold,
+		Text:   nonEmpty(text),
+	}
+}
+
+// Hero is a full-width 20:13 image. Cards with photos use cover mode, logos
+// use fit so nothing is cropped. An empty url falls back to the theme's
+// placeholder.
+func Hero(url string, mode linebot.FlexImageAspectModeType) *linebot.ImageComponent {
+	if url == "" {
+		url = theme.PlaceholderURL
+	}
+	return &linebot.ImageComponent{
+		Type:        linebot.FlexComponentTypeImage,
+		URL:         url,
+		Size:        linebot.FlexImageSizeTypeFull,
+		AspectRatio: linebot.FlexImageAspectRatioType20to13,
+		AspectMode:  mode,
+	}
+}
+
+// Box stacks components vertically.
+func Box(contents ...linebot.FlexComponent) *linebot.BoxComponent {
+	return &linebot.BoxComponent{
+		Type:     linebot.FlexComponentTypeBox,
+		Layout:   linebot.FlexBoxLayoutTypeVertical,
+		Contents: contents,
+	}
+}
+
+// Button is a primary button.
+func Button(action linebot.TemplateAction) *linebot.ButtonComponent {
+	return &linebot.ButtonComponent{
+		Type:   linebot.FlexComponentTypeButton,
+		Action: action,
+		Style:  linebot.FlexButtonStyleTypePrimary,
+		Color:  theme.ButtonColor,
+	}
+}
+
+// Buttons lays out buttons side by side; the first one is primary and the
+// rest secondary.
+func Buttons(actions ...linebot.TemplateAction) *linebot.BoxComponent {
+	contents := make([]linebot.FlexComponent, 0, len(actions))
+	for idx, action := range actions {
+		button := Button(action)
+		if idx > 0 {
+			button.Style = linebot.FlexButtonStyleTypeSecondary
+			button.Color = ""
+		}
+		contents = append(contents, button)
+	}
+	return &linebot.BoxComponent{
+		Type:     linebot.FlexComponentTypeBox,
+		Layout:   linebot.FlexBoxLayoutTypeHorizontal,
+		Spacing:  linebot.FlexComponentSpacingTypeSm,
+		Contents: contents,
+	}
+}
+
+// Bubble assembles a card. Any part may be nil.
+func Bubble(hero *linebot.ImageComponent, body, footer *linebot.BoxComponent) *linebot.BubbleContainer {
+	bubble := &linebot.BubbleContainer{
+		Type:   linebot.FlexContainerTypeBubble,
+		Body:   body,
+		Footer: footer,
+	}
+	// A nil *ImageComponent stored in the interface field would still be
+	// marshalled, so only set the hero when there is one.
+	if hero != nil && hero.URL != "" {
+		bubble.Hero = hero
+	}
+	return bubble
+}
+
+// ButtonCard is an image with a single button underneath.
+func ButtonCard(imageURL string, mode linebot.FlexImageAspectModeType, action linebot.TemplateAction) *linebot.BubbleContainer {
+	return Bubble(Hero(imageURL, mode), nil, Box(Button(action)))
+}
+
+// Card is a bubble with a one-line summary of it, e.g. "ABC-1234 小明
+// (北一群)", from which the alt text of its message is built.
+type Card struct {
+	Bubble *linebot.BubbleContainer
+	Alt    string
+}
+
+// Cards wraps bubbles whose summaries can be generated from their contents.
+func Cards(bubbles ...*linebot.BubbleContainer) []Card {
+	result := make([]Card, 0, len(bubbles))
+	for _, bubble := range bubbles {
+		result = append(result, Card{Bubble: bubble, Alt: Summary(bubble)})
+	}
+	return result
+}
+
+// Carousels groups cards into as few carousels as LINE allows, keeping both
+// the bubble count and the JSON size, wrapper included, under the limits.
+// Cards that are too large on their own are dropped.
+func Carousels(cards []Card) [][]Card {
+	result := make([][]Card, 0)
+	currentSize := 0
+	for _, card := range cards {
+		size := sizeOf(card.Bubble)
+		if size > MaxBubbleSize {
+			log.Printf("flex: dropping bubble of %d bytes", size)
+			continue
+		}
+		last := len(result) - 1
+		if last < 0 || len(result[last]) == MaxBubbles || currentSize+size+1 > MaxCarouselSize {
+			result = append(result, nil)
+			last++
+			currentSize = carouselOverhead - 1
+		}
+		result[last] = append(result[last], card)
+		currentSize += size + 1
+	}
+	return result
+}
+
+// Messages turns cards into at most limit messages titled title; cards
+// that do not fit are dropped. textOnly renders each carousel as a plain
+// text message instead, for chats that prefer it. The number of cards
+// actually shown is returned as well.
+func Messages(title string, cards []Card, limit int, textOnly bool) ([]linebot.SendingMessage, int) {
+	if limit <= 0 {
+		return nil, 0
+	}
+
+	carousels := Carousels(cards)
+	if len(carousels) > limit {
+		carousels = carousels[:limit]
+	}
+
+	shown := 0
+	messages := make([]linebot.SendingMessage, 0, len(carousels))
+	for _, carousel := range carousels {
+		shown += len(carousel)
+		if textOnly {
+			messages = append(messages, linebot.NewTextMessage(TextMessage(title, carousel)))
+			continue
+		}
+		contents := make([]*linebot.BubbleContainer, 0, len(carousel))
+		for _, card := range carousel {
+			contents = append(contents, card.Bubble)
+		}
+		messages = append(messages, linebot.NewFlexMessage(AltText(title, carousel), &linebot.CarouselContainer{
+			Type:     linebot.FlexContainerTypeCarousel,
+			Contents: contents,
+		}))
+	}
+	return messages, shown
+}
+
+// MoreCard is the trailing "more results" card.
+func MoreCard(text string, action linebot.TemplateAction) *Card {
+	bubble := Bubble(nil, Box(Text(text)), Box(Button(action)))
+	bubble.Size = linebot.FlexBubbleSizeTypeMicro
+	return &Card{Bubble: bubble, Alt: text}
+}
+
+func sizeOf(bubble *linebot.BubbleContainer) int {
+	data, err := json.Marshal(bubble)
+	if err != nil {
+		return MaxBubbleSize + 1
+	}
+	return len(data)
+}
+
+// nonEmpty guards against LINE rejecting text components with empty text.
+func nonEmpty(text string) string {
+	if text == "" {
+		return "-"
+	}
+	return text
+}
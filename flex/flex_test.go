@@ -0,0 +1,203 @@
+package flex
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares v, marshalled as indented JSON, with testdata/name.json.
+func golden(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name+".json")
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from golden file:\n%s", path, got)
+	}
+}
+
+func card(idx int) Card {
+	bubble := Bubble(nil, Box(Title(fmt.Sprintf("Card %d", idx)), Row("Plate", fmt.Sprintf("ABC-%04d", idx))), nil)
+	return Card{Bubble: bubble, Alt: fmt.Sprintf("ABC-%04d", idx)}
+}
+
+func cards(n int) []Card {
+	result := make([]Card, 0, n)
+	for idx := 1; idx <= n; idx++ {
+		result = append(result, card(idx))
+	}
+	return result
+}
+
+// sizedCard is a card whose bubble marshals to exactly size bytes.
+func sizedCard(t *testing.T, idx, size int) Card {
+	t.Helper()
+	bubble := Bubble(nil, Box(Text("")), nil)
+	padding := size - sizeOf(bubble) + 1
+	if padding < 1 {
+		t.Fatalf("size %d is too small", size)
+	}
+	bubble.Body.Contents[0].(*linebot.TextComponent).Text = strings.Repeat("x", padding)
+	if got := sizeOf(bubble); got != size {
+		t.Fatalf("sized card is %d bytes, want %d", got, size)
+	}
+	return Card{Bubble: bubble, Alt: fmt.Sprintf("card %d", idx)}
+}
+
+// layout summarises messages for golden files where the full JSON would be
+// mostly padding.
+type layout struct {
+	AltText string
+	Bubbles int
+	Size    int
+}
+
+func layoutOf(t *testing.T, messages []linebot.SendingMessage) []layout {
+	t.Helper()
+	result := make([]layout, 0, len(messages))
+	for _, message := range messages {
+		flexMessage, ok := message.(*linebot.FlexMessage)
+		if !ok {
+			t.Fatalf("got %T, want a flex message", message)
+		}
+		carousel := flexMessage.Contents.(*linebot.CarouselContainer)
+		data, err := json.Marshal(carousel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := layout{AltText: flexMessage.AltText, Bubbles: len(carousel.Contents), Size: len(data)}
+		if len(carousel.Contents) > MaxBubbles {
+			t.Errorf("carousel has %d bubbles", len(carousel.Contents))
+		}
+		if entry.Size > MaxCarouselSize {
+			t.Errorf("carousel is %d bytes", entry.Size)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestMessagesSplitsAtMaxBubbles(t *testing.T) {
+	messages, shown := Messages("Search", cards(13), MaxMessages, false)
+	if shown != 13 {
+		t.Errorf("shown = %d, want 13", shown)
+	}
+	golden(t, "split_bubbles", messages)
+}
+
+func TestMessagesSplitsBySize(t *testing.T) {
+	input := make([]Card, 0, 5)
+	for idx := 1; idx <= 5; idx++ {
+		input = append(input, sizedCard(t, idx, 20000))
+	}
+	messages, shown := Messages("Search", input, MaxMessages, false)
+	if shown != 5 {
+		t.Errorf("shown = %d, want 5", shown)
+	}
+	golden(t, "split_size", layoutOf(t, messages))
+}
+
+func TestMessagesDropsOversizedBubbles(t *testing.T) {
+	input := []Card{card(1), sizedCard(t, 2, MaxBubbleSize+1), card(3)}
+	messages, shown := Messages("Search", input, MaxMessages, false)
+	if shown != 2 {
+		t.Errorf("shown = %d, want 2", shown)
+	}
+	golden(t, "drop_oversized", layoutOf(t, messages))
+}
+
+func TestMessagesTruncatesAtLimit(t *testing.T) {
+	messages, shown := Messages("Search", cards(MaxMessages*MaxBubbles+3), MaxMessages, false)
+	if len(messages) != MaxMessages {
+		t.Errorf("got %d messages, want %d", len(messages), MaxMessages)
+	}
+	if want := MaxMessages * MaxBubbles; shown != want {
+		t.Errorf("shown = %d, want %d", shown, want)
+	}
+	golden(t, "truncate_count", layoutOf(t, messages))
+}
+
+func TestMessagesTruncatesToOne(t *testing.T) {
+	messages, shown := Messages("Search", cards(MaxBubbles+1), 1, false)
+	if shown != MaxBubbles {
+		t.Errorf("shown = %d, want %d", shown, MaxBubbles)
+	}
+	golden(t, "truncate_one", layoutOf(t, messages))
+}
+
+func TestMessagesEmpty(t *testing.T) {
+	messages, shown := Messages("Search", nil, MaxMessages, false)
+	if len(messages) != 0 || shown != 0 {
+		t.Errorf("got %d messages showing %d cards, want none", len(messages), shown)
+	}
+}
+
+func TestMessagesTextOnly(t *testing.T) {
+	input := append(cards(2), Card{
+		Bubble: Bubble(nil, Box(Badge("✔ Verified"), Text("Hello")),
+			Buttons(linebot.NewURIAction("Website", "https://kamiq.club"), linebot.NewPostbackAction("Join", "action=event", "", ""))),
+		Alt: "links",
+	})
+	messages, _ := Messages("Search", input, MaxMessages, true)
+	golden(t, "text_only", messages)
+}
+
+func TestAltText(t *testing.T) {
+	long := make([]Card, 0, 60)
+	for idx := 1; idx <= 60; idx++ {
+		long = append(long, Card{Alt: fmt.Sprintf("車牌 ABC-%04d", idx)})
+	}
+	got := map[string]string{
+		"title_and_cards": AltText("Search", cards(3)),
+		"cards_only":      AltText("", cards(2)),
+		"title_only":      AltText("Search", []Card{{}}),
+		"empty":           AltText("", nil),
+		"truncated":       AltText("Search", long),
+	}
+	if n := utf8.RuneCountInString(got["truncated"]); n != MaxAltText {
+		t.Errorf("truncated alt text has %d characters, want %d", n, MaxAltText)
+	}
+	golden(t, "alt_text", got)
+}
+
+func TestCarouselOverhead(t *testing.T) {
+	data, err := json.Marshal(&linebot.CarouselContainer{Type: linebot.FlexContainerTypeCarousel, Contents: []*linebot.BubbleContainer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != carouselOverhead {
+		t.Errorf("empty carousel is %d bytes, want %d: %s", len(data), carouselOverhead, data)
+	}
+}
+
+// Two bubbles that add up to exactly the limit no longer fit once the
+// carousel wrapper and comma are counted.
+func TestMessagesCountsCarouselWrapper(t *testing.T) {
+	input := []Card{sizedCard(t, 1, MaxCarouselSize/2), sizedCard(t, 2, MaxCarouselSize/2)}
+	messages, _ := Messages("Search", input, MaxMessages, false)
+	golden(t, "split_wrapper", layoutOf(t, messages))
+}
@@ -0,0 +1,120 @@
+package flex
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const (
+	// MaxAltText is LINE's limit on a Flex message's alt text in characters.
+	MaxAltText = 400
+	// MaxText is LINE's limit on a text message in characters.
+	MaxText = 5000
+)
+
+// AltText is what notifications and clients without Flex support show: the
+// title followed by each card's summary, cut to LINE's limit.
+func AltText(title string, cards []Card) string {
+	summaries := make([]string, 0, len(cards))
+	for _, card := range cards {
+		if card.Alt != "" {
+			summaries = append(summaries, card.Alt)
+		}
+	}
+	text := strings.Join(summaries, "、")
+	switch {
+	case text == "":
+		text = title
+	case title != "":
+		text = title + ": " + text
+	}
+	if text == "" {
+		text = "-"
+	}
+	return truncate(text, MaxAltText)
+}
+
+// TextMessage renders cards as one plain text message under title.
+func TextMessage(title string, cards []Card) string {
+	parts := make([]string, 0, len(cards)+1)
+	if title != "" {
+		parts = append(parts, title)
+	}
+	for _, card := range cards {
+		if text := PlainText(card.Bubble); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return truncate(strings.Join(parts, "\n\n"), MaxText)
+}
+
+// Summary takes the first few lines of a bubble's text, for bubbles whose
+// builders do not provide a summary of their own.
+func Summary(bubble *linebot.BubbleContainer) string {
+	lines := strings.Split(PlainText(bubble), "\n")
+	if len(lines) > 2 {
+		lines = lines[:2]
+	}
+	return strings.Join(lines, " ")
+}
+
+// PlainText renders a bubble as text: rows become "label: value" lines and
+// buttons show their link or the text to send. Postback buttons cannot be
+// used from text and are left out.
+func PlainText(bubble *linebot.BubbleContainer) string {
+	lines := make([]string, 0)
+	for _, box := range []*linebot.BoxComponent{bubble.Header, bubble.Body, bubble.Footer} {
+		if box != nil {
+			lines = appendText(lines, box)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func appendText(lines []string, component linebot.FlexComponent) []string {
+	switch c := component.(type) {
+	case *linebot.BoxComponent:
+		if c.Layout == linebot.FlexBoxLayoutTypeBaseline {
+			parts := make([]string, 0, len(c.Contents))
+			for _, content := range c.Contents {
+				if text, ok := content.(*linebot.TextComponent); ok {
+					parts = append(parts, text.Text)
+				}
+			}
+			if len(parts) > 0 {
+				lines = append(lines, strings.Join(parts, " "))
+			}
+			return lines
+		}
+		for _, content := range c.Contents {
+			lines = appendText(lines, content)
+		}
+	case *linebot.TextComponent:
+		lines = append(lines, c.Text)
+	case *linebot.ButtonComponent:
+		if text := actionText(c.Action); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return lines
+}
+
+func actionText(action linebot.TemplateAction) string {
+	switch a := action.(type) {
+	case *linebot.URIAction:
+		return a.Label + ": " + a.URI
+	case *linebot.MessageAction:
+		return a.Label + " → " + a.Text
+	}
+	return ""
+}
+
+func truncate(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const (
+	fuelRollingWindow = 5
+	fuelHistoryLimit  = 10
+	fuelMaxLitres     = 60
+	fuelMaxAmount     = 10000
+	firstModelYear    = 2019
+
+	// Fill-ups outside this range are almost always a partial fill or a
+	// missed log, so they are left out of the averages.
+	minPlausibleEconomy = 3
+	maxPlausibleEconomy = 40
+
+	// minFuelStatsCars keeps model years with fewer cars out of the club
+	// statistics, so no one's numbers can be singled out.
+	minFuelStatsCars = 3
+)
+
+var fuelRepo repositories.FuelRepository
+
+// fillUp is a logged fill-up with the distance driven since the previous
+// one; the first fill-up of a car has no distance or economy.
+type fillUp struct {
+	Log      repositories.FuelLog
+	Distance int
+	Economy  float64
+}
+
+func (f fillUp) plausible() bool {
+	return f.Distance > 0 && f.Economy >= minPlausibleEconomy && f.Economy <= maxPlausibleEconomy
+}
+
+// fillUps works out the economy of each fill-up from one car's logs in
+// odometer order, assuming each fill-up tops the tank up.
+func fillUps(logs []repositories.FuelLog) []fillUp {
+	result := make([]fillUp, 0, len(logs))
+	for idx, entry := range logs {
+		fill := fillUp{Log: entry}
+		if idx > 0 && entry.Litres > 0 {
+			fill.Distance = entry.Odometer - logs[idx-1].Odometer
+			fill.Economy = float64(fill.Distance) / entry.Litres
+		}
+		result = append(result, fill)
+	}
+	return result
+}
+
+// averageEconomy is total distance over total litres of the plausible
+// fill-ups, which weights long tanks more than averaging the ratios would.
+func averageEconomy(fills []fillUp) (float64, bool) {
+	distance, litres := 0, 0.0
+	for _, fill := range fills {
+		if fill.plausible() {
+			distance += fill.Distance
+			litres += fill.Log.Litres
+		}
+	}
+	if litres == 0 {
+		return 0, false
+	}
+	return float64(distance) / litres, true
+}
+
+func rollingEconomy(fills []fillUp) (float64, bool) {
+	if len(fills) > fuelRollingWindow {
+		fills = fills[len(fills)-fuelRollingWindow:]
+	}
+	return averageEconomy(fills)
+}
+
+// fuelCommand runs "加油 [車牌] 里程 公升 金額" to log a fill-up, "加油" for
+// the history, "加油 年式 年份 [車牌]" and "加油 刪除 編號". Logs are
+// private, so it only works in 1:1 chat; groups get "油耗" instead.
+func fuelCommand(ctx *commandContext) {
+	if ctx.GroupID != "" {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.private"))
+		return
+	}
+	fields := strings.Fields(ctx.Args)
+	if len(fields) == 0 {
+		replyFuelHistory(ctx, "")
+		return
+	}
+
+	switch fields[0] {
+	case "紀錄", "記錄":
+		replyFuelHistory(ctx, strings.Join(fields[1:], " "))
+	case "刪除":
+		if len(fields) != 2 {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.usage"))
+			return
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(fields[1], "#"))
+		if err != nil {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.usage"))
+			return
+		}
+		deleted, err := fuelRepo.Delete(ctx.UserID, id)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if !deleted {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.not_found", id))
+			return
+		}
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.deleted", id))
+	case "年式":
+		setModelYear(ctx, fields[1:])
+	default:
+		logFuel(ctx, fields)
+	}
+}
+
+// resolvePlate picks the car a fuel command is about: the plate given, or
+// the user's only registered plate. It replies and returns false otherwise.
+func resolvePlate(ctx *commandContext, plate string) (string, bool) {
+	plates, err := registeredPlates(ctx.UserID)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	if len(plates) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.unregistered"))
+		return "", false
+	}
+	if plate == "" {
+		if len(plates) > 1 {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.which_plate", strings.Join(plates, "、")))
+			return "", false
+		}
+		return plates[0], true
+	}
+	plate = strings.ToUpper(plate)
+	if !containsString(plates, plate) {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.unknown_plate", plate, strings.Join(plates, "、")))
+		return "", false
+	}
+	return plate, true
+}
+
+func isPlate(text string) bool {
+	return newLicensePlateNumberRegexp.MatchString(text) || oldLicensePlateNumberRegexp.MatchString(text)
+}
+
+func logFuel(ctx *commandContext, fields []string) {
+	plateArg := ""
+	if isPlate(fields[0]) {
+		plateArg, fields = fields[0], fields[1:]
+	}
+	if len(fields) != 3 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.usage"))
+		return
+	}
+	odometer, err := parseAmount(strings.TrimSuffix(fields[0], "km"))
+	if err != nil || odometer <= 0 || odometer > maintenanceMaxMileage {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.invalid_odometer"))
+		return
+	}
+	litres, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToUpper(fields[1]), "L"), 64)
+	if err != nil || litres <= 0 || litres > fuelMaxLitres {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.invalid_litres", fuelMaxLitres))
+		return
+	}
+	amount, err := parseAmount(fields[2])
+	if err != nil || amount < 0 || amount > fuelMaxAmount {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.invalid_amount"))
+		return
+	}
+
+	plate, ok := resolvePlate(ctx, plateArg)
+	if !ok {
+		return
+	}
+	logs, err := fuelRepo.ListByPlate(ctx.UserID, plate)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if n := len(logs); n > 0 && odometer <= logs[n-1].Odometer {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.odometer_behind", logs[n-1].Odometer))
+		return
+	}
+
+	entry := repositories.FuelLog{
+		UserID:             ctx.UserID,
+		LicensePlateNumber: plate,
+		Odometer:           odometer,
+		Litres:             litres,
+		Amount:             amount,
+		FilledAt:           time.Now(),
+	}
+	if entry.ID, err = fuelRepo.Create(entry); err != nil {
+		log.Println(err)
+		return
+	}
+
+	fills := fillUps(append(logs, entry))
+	latest := fills[len(fills)-1]
+	lines := []string{i18n.T(ctx.Lang, "fuel.logged", entry.ID, plate)}
+	if latest.Distance > 0 {
+		lines = append(lines, i18n.T(ctx.Lang, "fuel.this_fill", latest.Distance, litres, latest.Economy))
+	} else {
+		lines = append(lines, i18n.T(ctx.Lang, "fuel.first_fill"))
+	}
+	if amount > 0 {
+		lines = append(lines, i18n.T(ctx.Lang, "fuel.unit_price", float64(amount)/litres))
+	}
+	lines = append(lines, fuelAverages(ctx.Lang, fills)...)
+	replyText(ctx.Event.ReplyToken, strings.Join(lines, "\n"))
+}
+
+func fuelAverages(lang string, fills []fillUp) []string {
+	var lines []string
+	if economy, ok := rollingEconomy(fills); ok {
+		lines = append(lines, i18n.T(lang, "fuel.rolling", fuelRollingWindow, economy))
+	}
+	if economy, ok := averageEconomy(fills); ok {
+		lines = append(lines, i18n.T(lang, "fuel.overall", economy))
+	}
+	return lines
+}
+
+func replyFuelHistory(ctx *commandContext, plateArg string) {
+	plate, ok := resolvePlate(ctx, plateArg)
+	if !ok {
+		return
+	}
+	logs, err := fuelRepo.ListByPlate(ctx.UserID, plate)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(logs) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.none"))
+		return
+	}
+
+	title := i18n.T(ctx.Lang, "fuel.history", plate)
+	if vehicle, err := fuelRepo.GetVehicle(ctx.UserID, plate); err == nil && vehicle.ModelYear > 0 {
+		title += " " + i18n.T(ctx.Lang, "fuel.model_year_label", vehicle.ModelYear)
+	} else if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		log.Println(err)
+	}
+	lines := []string{title}
+
+	fills := fillUps(logs)
+	for idx := len(fills) - 1; idx >= 0 && idx >= len(fills)-fuelHistoryLimit; idx-- {
+		fill := fills[idx]
+		line := fmt.Sprintf("#%d %s %d km %.1f L", fill.Log.ID, formatServiceDate(fill.Log.FilledAt), fill.Log.Odometer, fill.Log.Litres)
+		if fill.Log.Amount > 0 {
+			line += fmt.Sprintf(" $%d", fill.Log.Amount)
+		}
+		if fill.Distance > 0 {
+			line += fmt.Sprintf(" → %.1f km/L", fill.Economy)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fuelAverages(ctx.Lang, fills)...)
+	lines = append(lines, i18n.T(ctx.Lang, "fuel.history_hint"))
+	replyText(ctx.Event.ReplyToken, strings.Join(lines, "\n"))
+}
+
+func setModelYear(ctx *commandContext, fields []string) {
+	if len(fields) == 0 || len(fields) > 2 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.usage"))
+		return
+	}
+	year, err := strconv.Atoi(fields[0])
+	if err != nil || year < firstModelYear || year > time.Now().Year()+1 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.invalid_year", firstModelYear, time.Now().Year()+1))
+		return
+	}
+	plateArg := ""
+	if len(fields) == 2 {
+		plateArg = fields[1]
+	}
+	plate, ok := resolvePlate(ctx, plateArg)
+	if !ok {
+		return
+	}
+	if err := fuelRepo.SetModelYear(repositories.FuelVehicle{
+		UserID:             ctx.UserID,
+		LicensePlateNumber: plate,
+		ModelYear:          year,
+	}); err != nil {
+		log.Println(err)
+		return
+	}
+	replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.year_set", plate, year))
+}
+
+// fuelStatsCommand shows the club's average economy by model year. Each
+// car counts once, with its own overall average, and only model years
+// with at least minFuelStatsCars cars are listed. The club average only
+// covers the listed years, so the hidden ones cannot be worked out from it.
+func fuelStatsCommand(ctx *commandContext) {
+	cars, err := fuelRepo.ListCarEconomies(minPlausibleEconomy, maxPlausibleEconomy)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	byYear := map[int][]float64{}
+	for _, car := range cars {
+		byYear[car.ModelYear] = append(byYear[car.ModelYear], car.Economy)
+	}
+
+	yearList := make([]int, 0, len(byYear))
+	for year, economies := range byYear {
+		if len(economies) >= minFuelStatsCars {
+			yearList = append(yearList, year)
+		}
+	}
+	sort.Ints(yearList)
+
+	var all []float64
+	yearLines := make([]string, 0, len(yearList))
+	for _, year := range yearList {
+		economies := byYear[year]
+		all = append(all, economies...)
+		label := i18n.T(ctx.Lang, "fuel.model_year_label", year)
+		if year == 0 {
+			label = i18n.T(ctx.Lang, "fuel.year_unset")
+		}
+		yearLines = append(yearLines, i18n.T(ctx.Lang, "fuel.stats_line", label, mean(economies), len(economies)))
+	}
+	if len(all) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "fuel.stats_none", minFuelStatsCars))
+		return
+	}
+
+	lines := append([]string{i18n.T(ctx.Lang, "fuel.stats_title", mean(all), len(all))}, yearLines...)
+	lines = append(lines, i18n.T(ctx.Lang, "fuel.stats_note", minFuelStatsCars))
+	replyText(ctx.Event.ReplyToken, strings.Join(lines, "\n"))
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,110 @@
+// Package geo holds the coarse Taiwan geography the bot needs: counties by
+// region with approximate centres, address parsing and distance helpers.
+package geo
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+type County struct {
+	Name      string
+	Region    string
+	Latitude  float64
+	Longitude float64
+}
+
+var Regions = []string{"北部", "中部", "南部", "東部", "離島"}
+
+// Counties are listed north to south within each region; the coordinates
+// are rough centres of the county seats.
+var Counties = []County{
+	{"基隆市", "北部", 25.13, 121.74},
+	{"臺北市", "北部", 25.04, 121.56},
+	{"新北市", "北部", 25.01, 121.46},
+	{"桃園市", "北部", 24.99, 121.30},
+	{"新竹市", "北部", 24.80, 120.97},
+	{"新竹縣", "北部", 24.84, 121.01},
+	{"宜蘭縣", "北部", 24.70, 121.74},
+	{"苗栗縣", "中部", 24.56, 120.82},
+	{"臺中市", "中部", 24.15, 120.67},
+	{"彰化縣", "中部", 24.08, 120.54},
+	{"南投縣", "中部", 23.91, 120.68},
+	{"雲林縣", "中部", 23.71, 120.43},
+	{"嘉義市", "南部", 23.48, 120.45},
+	{"嘉義縣", "南部", 23.46, 120.29},
+	{"臺南市", "南部", 22.99, 120.21},
+	{"高雄市", "南部", 22.63, 120.30},
+	{"屏東縣", "南部", 22.67, 120.49},
+	{"花蓮縣", "東部", 23.99, 121.60},
+	{"臺東縣", "東部", 22.76, 121.14},
+	{"澎湖縣", "離島", 23.57, 119.58},
+	{"金門縣", "離島", 24.43, 118.32},
+	{"連江縣", "離島", 26.16, 119.95},
+}
+
+var districtRegexp = regexp.MustCompile(`^\p{Han}{1,3}?[區鄉鎮市]`)
+
+// Normalize maps the common 台 spelling to the official 臺.
+func Normalize(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "台", "臺")
+}
+
+func CountiesIn(region string) []County {
+	result := make([]County, 0)
+	for _, county := range Counties {
+		if county.Region == region {
+			result = append(result, county)
+		}
+	}
+	return result
+}
+
+func FindCounty(name string) (County, bool) {
+	name = Normalize(name)
+	for _, county := range Counties {
+		if county.Name == name {
+			return county, true
+		}
+	}
+	return County{}, false
+}
+
+// ValidDistrict reports whether s looks like a township-level name such as
+// 大安區 or 龜山鄉.
+func ValidDistrict(s string) bool {
+	s = Normalize(s)
+	return districtRegexp.FindString(s) == s
+}
+
+// ParseAddress extracts the county and district from a free-form address
+// like LINE location messages carry, e.g. "10491台北市中山區...".
+func ParseAddress(address string) (city, district string) {
+	address = Normalize(address)
+	for _, county := range Counties {
+		idx := strings.Index(address, county.Name)
+		if idx < 0 {
+			continue
+		}
+		rest := address[idx+len(county.Name):]
+		return county.Name, districtRegexp.FindString(rest)
+	}
+	return "", ""
+}
+
+// Coarsen rounds coordinates to two decimals (roughly 1 km) so exact home
+// or work addresses are never stored.
+func Coarsen(lat, lng float64) (float64, float64) {
+	return math.Round(lat*100) / 100, math.Round(lng*100) / 100
+}
+
+// Distance returns the great-circle distance in kilometres.
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadius = 6371.0
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
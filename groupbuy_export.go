@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+// LINE cannot send files, so organizers get a signed link to download the
+// order summary instead. Links expire after groupBuyExportTTL.
+const groupBuyExportTTL = 24 * time.Hour
+
+// groupBuyExportSecret signs export links: GROUPBUY_EXPORT_SECRET, or the
+// channel secret when unset.
+func groupBuyExportSecret() []byte {
+	if secret := os.Getenv("GROUPBUY_EXPORT_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(os.Getenv("CHANNEL_SECRET"))
+}
+
+func signGroupBuyExport(id int, expires int64) string {
+	mac := hmac.New(sha256.New, groupBuyExportSecret())
+	fmt.Fprintf(mac, "groupbuy:%d:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// groupBuyExportURL builds a download link valid for groupBuyExportTTL from
+// now. It needs PUBLIC_URL, the address the bot is reachable at.
+func groupBuyExportURL(id int, now time.Time) (string, bool) {
+	base := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if base == "" {
+		return "", false
+	}
+	expires := now.Add(groupBuyExportTTL).Unix()
+	query := url.Values{}
+	query.Set("id", strconv.Itoa(id))
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	query.Set("sig", signGroupBuyExport(id, expires))
+	return base + "/groupbuys/export?" + query.Encode(), true
+}
+
+// exportGroupBuy pushes the download link to the organizer or admin who
+// asked, in 1:1 chat, so it is not shared with the whole group.
+func exportGroupBuy(ctx *commandContext, buy repositories.GroupBuy) {
+	if !canManageGroupBuy(ctx.UserID, buy) {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.manage_denied"))
+		return
+	}
+	link, ok := groupBuyExportURL(buy.ID, time.Now())
+	if !ok {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.export_unavailable"))
+		return
+	}
+	message := linebot.NewTextMessage(i18n.T(ctx.Lang, "groupbuy.export_link", buy.Title, link))
+	if _, err := bot.PushMessage(ctx.UserID, message).Do(); err != nil {
+		log.Println(err)
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.export_add_friend"))
+		return
+	}
+	replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.export_sent"))
+}
+
+// groupBuyExportHandler serves the order summary as CSV, one row per buyer
+// and item, for links signed by groupBuyExportURL.
+func groupBuyExportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	id, err := strconv.Atoi(query.Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(signGroupBuyExport(id, expires))) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	buy, err := groupBuyRepo.Get(id)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	orders, err := groupBuyRepo.ListOrders(id)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	lang := languageOf(buy.GroupID)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="groupbuy-%d.csv"`, id))
+	// The byte order mark makes Excel read the file as UTF-8.
+	w.Write([]byte("\xef\xbb\xbf"))
+
+	out := csv.NewWriter(w)
+	out.Write(strings.Split(i18n.T(lang, "groupbuy.csv_header"), ","))
+	total := 0
+	for _, buyer := range groupOrdersByBuyer(buy, orders) {
+		for _, order := range buyer.Orders {
+			item, ok := findItem(buy, order.ItemID)
+			if !ok {
+				continue
+			}
+			subtotal := item.Price * order.Quantity
+			total += subtotal
+			out.Write([]string{
+				csvText(buyer.UserName),
+				csvText(item.Name),
+				csvText(item.Variant),
+				strconv.Itoa(item.Price),
+				strconv.Itoa(order.Quantity),
+				strconv.Itoa(subtotal),
+				paidText(lang, order.Paid),
+			})
+		}
+	}
+	out.Write([]string{i18n.T(lang, "groupbuy.csv_total"), "", "", "", "", strconv.Itoa(total), ""})
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Println(err)
+	}
+}
+
+// csvText keeps spreadsheets from running user input as a formula, by
+// prefixing a quote to values starting with a formula character.
+func csvText(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@\t\r") {
+		return "'" + value
+	}
+	return value
+}
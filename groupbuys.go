@@ -0,0 +1,601 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/flex"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const (
+	maxGroupBuyItems      = 10
+	maxGroupBuyItemLength = 20
+	maxGroupBuyQuantity   = 99
+	groupBuyCloseInterval = time.Minute
+)
+
+var groupBuyRepo repositories.GroupBuysRepository
+
+// groupBuyPriceRegexp matches the trailing price of an item such as
+// "族貼 黑 350", "遮陽簾 $1200" or "防跳石網 1,500元".
+var groupBuyPriceRegexp = regexp.MustCompile(`^(.+?)\s+(?:NT)?\$?([0-9][0-9,]*)\s*元?$`)
+
+// groupBuyCommand runs "團購 開團 標題 | 截止 時間 | 品項 [規格] 價格 | ..."
+// to open a buy, and "團購 [列表]", "團購 訂 編號 品項號碼 數量",
+// "團購 我的/取消/明細/結單/匯出 編號", "團購 付款/未付款 編號 @成員" to
+// follow up.
+func groupBuyCommand(ctx *commandContext) {
+	sub, rest := ctx.Args, ""
+	if idx := strings.IndexAny(ctx.Args, " \n"); idx >= 0 {
+		sub, rest = ctx.Args[:idx], strings.TrimSpace(ctx.Args[idx+1:])
+	}
+
+	switch sub {
+	case "", "列表":
+		listGroupBuys(ctx)
+	case "開團":
+		createGroupBuy(ctx, rest)
+	case "訂":
+		fields := strings.Fields(rest)
+		if len(fields) != 3 {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.usage"))
+			return
+		}
+		buy, ok := findGroupBuy(ctx.Event.ReplyToken, ctx.Lang, ctx.GroupID, fields[0])
+		if !ok {
+			return
+		}
+		number, err := strconv.Atoi(fields[1])
+		if err != nil || number < 1 || number > len(buy.Items) {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.invalid_item", len(buy.Items)))
+			return
+		}
+		quantity, err := strconv.Atoi(fields[2])
+		if err != nil || quantity < 0 || quantity > maxGroupBuyQuantity {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.invalid_quantity", maxGroupBuyQuantity))
+			return
+		}
+		setGroupBuyQuantity(ctx.Event.ReplyToken, ctx.Lang, ctx.UserID, buy, buy.Items[number-1], quantity)
+	case "付款", "未付款":
+		idText := rest
+		if idx := strings.IndexAny(rest, " \n"); idx >= 0 {
+			idText = rest[:idx]
+		}
+		buy, ok := findGroupBuy(ctx.Event.ReplyToken, ctx.Lang, ctx.GroupID, idText)
+		if !ok {
+			return
+		}
+		markGroupBuyPaid(ctx, buy, sub == "付款")
+	case "我的", "取消", "明細", "結單", "匯出":
+		buy, ok := findGroupBuy(ctx.Event.ReplyToken, ctx.Lang, ctx.GroupID, rest)
+		if !ok {
+			return
+		}
+		switch sub {
+		case "我的":
+			replyMyGroupBuyOrder(ctx.Event.ReplyToken, ctx.Lang, ctx.UserID, buy)
+		case "取消":
+			cancelGroupBuyOrder(ctx.Event.ReplyToken, ctx.Lang, ctx.UserID, buy)
+		case "明細":
+			replyGroupBuySummary(ctx.Event.ReplyToken, ctx.Lang, buy)
+		case "結單":
+			closeGroupBuy(ctx, buy)
+		case "匯出":
+			exportGroupBuy(ctx, buy)
+		}
+	default:
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.usage"))
+	}
+}
+
+func createGroupBuy(ctx *commandContext, args string) {
+	fields := splitFields(args)
+	if len(fields) < 3 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.create_usage"))
+		return
+	}
+
+	buy := repositories.GroupBuy{
+		GroupID:     ctx.GroupID,
+		Title:       fields[0],
+		OrganizerID: ctx.UserID,
+	}
+	seen := map[string]bool{}
+	for _, field := range fields[1:] {
+		if _, text, found := cutDeadline("|" + field); found {
+			deadline, err := parseClubTime(text)
+			if err != nil {
+				replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.invalid_time", text))
+				return
+			}
+			if deadline.Before(time.Now()) {
+				replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.past_time"))
+				return
+			}
+			buy.Deadline = deadline
+			continue
+		}
+
+		item, ok := parseGroupBuyItem(field)
+		if !ok {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.invalid_item_line", field))
+			return
+		}
+		label := itemLabel(item)
+		if utf8.RuneCountInString(label) > maxGroupBuyItemLength {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.item_too_long", maxGroupBuyItemLength))
+			return
+		}
+		if seen[label] {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.duplicate", label))
+			return
+		}
+		seen[label] = true
+		item.Position = len(buy.Items)
+		buy.Items = append(buy.Items, item)
+	}
+
+	switch {
+	case buy.Deadline.IsZero():
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.no_deadline"))
+		return
+	case len(buy.Items) == 0:
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.create_usage"))
+		return
+	case len(buy.Items) > maxGroupBuyItems:
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.too_many", maxGroupBuyItems))
+		return
+	}
+
+	id, err := groupBuyRepo.Create(buy)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	buy.ID = id
+	replyCarousel(ctx.Event.ReplyToken, ctx.GroupID, buy.Title, []flex.Card{makeGroupBuyCard(ctx.Lang, buy, nil)},
+		linebot.NewTextMessage(i18n.T(ctx.Lang, "groupbuy.created", buy.ID)))
+}
+
+// parseGroupBuyItem reads "名稱 [規格] 價格"; everything between the first
+// word and the price is the variant.
+func parseGroupBuyItem(text string) (repositories.GroupBuyItem, bool) {
+	m := groupBuyPriceRegexp.FindStringSubmatch(text)
+	if m == nil {
+		return repositories.GroupBuyItem{}, false
+	}
+	price, err := strconv.Atoi(strings.ReplaceAll(m[2], ",", ""))
+	if err != nil {
+		return repositories.GroupBuyItem{}, false
+	}
+	words := strings.Fields(m[1])
+	return repositories.GroupBuyItem{
+		Name:    words[0],
+		Variant: strings.Join(words[1:], " "),
+		Price:   price,
+	}, true
+}
+
+func itemLabel(item repositories.GroupBuyItem) string {
+	return strings.TrimSpace(item.Name + " " + item.Variant)
+}
+
+func listGroupBuys(ctx *commandContext) {
+	buys, err := groupBuyRepo.ListOpen(ctx.GroupID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(buys) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.none"))
+		return
+	}
+	cards := make([]flex.Card, 0, len(buys))
+	for _, buy := range buys {
+		orders, err := groupBuyRepo.ListOrders(buy.ID)
+		if err != nil {
+			log.Println(err)
+		}
+		cards = append(cards, makeGroupBuyCard(ctx.Lang, buy, orders))
+	}
+	replyCarousel(ctx.Event.ReplyToken, ctx.GroupID, i18n.T(ctx.Lang, "groupbuy.open"), cards)
+}
+
+// findGroupBuy looks up a group-buy by number, only within the group it
+// belongs to.
+func findGroupBuy(replyToken, lang, groupID, idText string) (repositories.GroupBuy, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(idText, "#"))
+	if err != nil {
+		replyText(replyToken, i18n.T(lang, "groupbuy.usage"))
+		return repositories.GroupBuy{}, false
+	}
+	buy, err := groupBuyRepo.Get(id)
+	if err != nil || buy.GroupID != groupID {
+		if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			log.Println(err)
+		}
+		replyText(replyToken, i18n.T(lang, "groupbuy.not_found", idText))
+		return repositories.GroupBuy{}, false
+	}
+	return buy, true
+}
+
+// canManageGroupBuy lets the organizer and admins close, export and mark
+// payments.
+func canManageGroupBuy(userID string, buy repositories.GroupBuy) bool {
+	return buy.OrganizerID == userID || hasRole(userID, buy.GroupID, repositories.RoleAdmin)
+}
+
+func countItems(orders []repositories.GroupBuyOrder) map[int]int {
+	counts := map[int]int{}
+	for _, order := range orders {
+		counts[order.ItemID] += order.Quantity
+	}
+	return counts
+}
+
+// makeGroupBuyCard lists the items with the quantity ordered so far and
+// one "+1" button per item.
+func makeGroupBuyCard(lang string, buy repositories.GroupBuy, orders []repositories.GroupBuyOrder) flex.Card {
+	counts := countItems(orders)
+	body := []linebot.FlexComponent{
+		flex.Title(buy.Title),
+		flex.Row(i18n.T(lang, "groupbuy.deadline"), formatClubTime(buy.Deadline)),
+		flex.Row(i18n.T(lang, "groupbuy.organizer"), displayName(buy.GroupID, buy.OrganizerID)),
+	}
+	for idx, item := range buy.Items {
+		body = append(body, flex.Row(fmt.Sprintf("%d. %s", idx+1, itemLabel(item)),
+			i18n.T(lang, "groupbuy.item_value", item.Price, counts[item.ID])))
+	}
+	body = append(body, flex.Text(i18n.T(lang, "groupbuy.hint", buy.ID)))
+
+	buttons := make([]linebot.FlexComponent, 0, len(buy.Items)+1)
+	for _, item := range buy.Items {
+		button := flex.Button(linebot.NewPostbackAction(
+			"+1 "+itemLabel(item),
+			fmt.Sprintf("action=buy&id=%d&item=%d", buy.ID, item.ID), "", ""))
+		button.Style = linebot.FlexButtonStyleTypeSecondary
+		button.Color = ""
+		buttons = append(buttons, button)
+	}
+	buttons = append(buttons, flex.Button(linebot.NewPostbackAction(
+		i18n.T(lang, "groupbuy.mine"), fmt.Sprintf("action=buy&id=%d&op=mine", buy.ID), "", "")))
+	footer := flex.Box(buttons...)
+	footer.Spacing = linebot.FlexComponentSpacingTypeSm
+
+	items := make([]string, 0, len(buy.Items))
+	for _, item := range buy.Items {
+		items = append(items, fmt.Sprintf("%s $%d", itemLabel(item), item.Price))
+	}
+	return flex.Card{
+		Bubble: flex.Bubble(nil, flex.Box(body...), footer),
+		Alt:    fmt.Sprintf("%s (%s)", buy.Title, strings.Join(items, " / ")),
+	}
+}
+
+// addGroupBuyItem orders one more of the item, as the card buttons do.
+func addGroupBuyItem(replyToken, lang, userID string, buy repositories.GroupBuy, item repositories.GroupBuyItem) {
+	if !buy.Open() {
+		replyText(replyToken, i18n.T(lang, "groupbuy.closed", buy.Title))
+		return
+	}
+	name := displayName(buy.GroupID, userID)
+	quantity, added, err := groupBuyRepo.AddQuantity(repositories.GroupBuyOrder{
+		GroupBuyID: buy.ID,
+		ItemID:     item.ID,
+		UserID:     userID,
+		UserName:   name,
+		Quantity:   1,
+	}, maxGroupBuyQuantity)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !added {
+		replyText(replyToken, i18n.T(lang, "groupbuy.quantity_limit", name, itemLabel(item), maxGroupBuyQuantity))
+		return
+	}
+	replyText(replyToken, i18n.T(lang, "groupbuy.ordered", name, itemLabel(item), quantity, buy.ID))
+}
+
+// setGroupBuyQuantity replaces the user's quantity of the item; 0 removes
+// it.
+func setGroupBuyQuantity(replyToken, lang, userID string, buy repositories.GroupBuy, item repositories.GroupBuyItem, quantity int) {
+	if !buy.Open() {
+		replyText(replyToken, i18n.T(lang, "groupbuy.closed", buy.Title))
+		return
+	}
+	name := displayName(buy.GroupID, userID)
+	if err := groupBuyRepo.SetQuantity(repositories.GroupBuyOrder{
+		GroupBuyID: buy.ID,
+		ItemID:     item.ID,
+		UserID:     userID,
+		UserName:   name,
+		Quantity:   quantity,
+	}); err != nil {
+		log.Println(err)
+		return
+	}
+	if quantity == 0 {
+		replyText(replyToken, i18n.T(lang, "groupbuy.removed", name, itemLabel(item), buy.ID))
+		return
+	}
+	replyText(replyToken, i18n.T(lang, "groupbuy.ordered", name, itemLabel(item), quantity, buy.ID))
+}
+
+func cancelGroupBuyOrder(replyToken, lang, userID string, buy repositories.GroupBuy) {
+	if !buy.Open() {
+		replyText(replyToken, i18n.T(lang, "groupbuy.closed", buy.Title))
+		return
+	}
+	count, err := groupBuyRepo.DeleteOrders(buy.ID, userID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if count == 0 {
+		replyText(replyToken, i18n.T(lang, "groupbuy.no_order", buy.Title))
+		return
+	}
+	replyText(replyToken, i18n.T(lang, "groupbuy.cancelled", displayName(buy.GroupID, userID), buy.Title))
+}
+
+// buyerOrders groups the orders by buyer, keeping the repository's order.
+type buyerOrders struct {
+	UserID   string
+	UserName string
+	Orders   []repositories.GroupBuyOrder
+	Total    int
+	Paid     bool
+}
+
+func groupOrdersByBuyer(buy repositories.GroupBuy, orders []repositories.GroupBuyOrder) []*buyerOrders {
+	prices := map[int]int{}
+	for _, item := range buy.Items {
+		prices[item.ID] = item.Price
+	}
+	var result []*buyerOrders
+	byUser := map[string]*buyerOrders{}
+	for _, order := range orders {
+		buyer, ok := byUser[order.UserID]
+		if !ok {
+			buyer = &buyerOrders{UserID: order.UserID, UserName: order.UserName, Paid: true}
+			byUser[order.UserID] = buyer
+			result = append(result, buyer)
+		}
+		buyer.Orders = append(buyer.Orders, order)
+		buyer.Total += prices[order.ItemID] * order.Quantity
+		buyer.Paid = buyer.Paid && order.Paid
+	}
+	return result
+}
+
+func findItem(buy repositories.GroupBuy, itemID int) (repositories.GroupBuyItem, bool) {
+	for _, item := range buy.Items {
+		if item.ID == itemID {
+			return item, true
+		}
+	}
+	return repositories.GroupBuyItem{}, false
+}
+
+func describeOrders(buy repositories.GroupBuy, orders []repositories.GroupBuyOrder) string {
+	parts := make([]string, 0, len(orders))
+	for _, order := range orders {
+		if item, ok := findItem(buy, order.ItemID); ok {
+			parts = append(parts, fmt.Sprintf("%s x%d", itemLabel(item), order.Quantity))
+		}
+	}
+	return strings.Join(parts, "、")
+}
+
+func paidText(lang string, paid bool) string {
+	if paid {
+		return i18n.T(lang, "groupbuy.paid")
+	}
+	return i18n.T(lang, "groupbuy.unpaid")
+}
+
+func replyMyGroupBuyOrder(replyToken, lang, userID string, buy repositories.GroupBuy) {
+	orders, err := groupBuyRepo.ListOrders(buy.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, buyer := range groupOrdersByBuyer(buy, orders) {
+		if buyer.UserID == userID {
+			replyText(replyToken, i18n.T(lang, "groupbuy.my_order", buyer.UserName, buy.Title,
+				describeOrders(buy, buyer.Orders), buyer.Total, paidText(lang, buyer.Paid), buy.ID))
+			return
+		}
+	}
+	replyText(replyToken, i18n.T(lang, "groupbuy.no_order", buy.Title))
+}
+
+// groupBuySummary renders item totals followed by each buyer's order and
+// payment status under the title key. Buyers that would push the text past
+// LINE's limit are left out and counted, pointing to the CSV export.
+func groupBuySummary(lang string, buy repositories.GroupBuy, orders []repositories.GroupBuyOrder, title string) string {
+	buyers := groupOrdersByBuyer(buy, orders)
+	total, unpaid := 0, 0
+	for _, buyer := range buyers {
+		total += buyer.Total
+		if !buyer.Paid {
+			unpaid++
+		}
+	}
+
+	counts := countItems(orders)
+	lines := []string{i18n.T(lang, title, buy.Title, len(buyers), total)}
+	for idx, item := range buy.Items {
+		lines = append(lines, i18n.T(lang, "groupbuy.summary_item", idx+1, itemLabel(item), item.Price, counts[item.ID]))
+	}
+	if len(buyers) > 0 {
+		lines = append(lines, "")
+	}
+
+	footer := make([]string, 0, 2)
+	if unpaid > 0 {
+		footer = append(footer, i18n.T(lang, "groupbuy.summary_unpaid", unpaid))
+	}
+	if buy.Open() {
+		footer = append(footer, fmt.Sprintf("%s: %s", i18n.T(lang, "groupbuy.deadline"), formatClubTime(buy.Deadline)))
+	}
+
+	// Room is kept for the footer and the line about omitted buyers.
+	size := utf8.RuneCountInString(strings.Join(append(lines, footer...), "\n")) +
+		utf8.RuneCountInString(i18n.T(lang, "groupbuy.summary_more", len(buyers), buy.ID)) + 1
+	for idx, buyer := range buyers {
+		line := i18n.T(lang, "groupbuy.summary_buyer", buyer.UserName,
+			describeOrders(buy, buyer.Orders), buyer.Total, paidText(lang, buyer.Paid))
+		size += utf8.RuneCountInString(line) + 1
+		if size > flex.MaxText {
+			lines = append(lines, i18n.T(lang, "groupbuy.summary_more", len(buyers)-idx, buy.ID))
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(append(lines, footer...), "\n")
+}
+
+func replyGroupBuySummary(replyToken, lang string, buy repositories.GroupBuy) {
+	orders, err := groupBuyRepo.ListOrders(buy.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	title := "groupbuy.summary"
+	if !buy.Open() {
+		title = "groupbuy.final"
+	}
+	replyText(replyToken, groupBuySummary(lang, buy, orders, title))
+}
+
+// markGroupBuyPaid sets the payment status of every mentioned buyer.
+func markGroupBuyPaid(ctx *commandContext, buy repositories.GroupBuy, paid bool) {
+	if !canManageGroupBuy(ctx.UserID, buy) {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.manage_denied"))
+		return
+	}
+	if ctx.Mention == nil || len(ctx.Mention.Mentionees) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.mention"))
+		return
+	}
+
+	var names []string
+	for _, mentionee := range ctx.Mention.Mentionees {
+		if mentionee.UserID == "" {
+			continue
+		}
+		found, err := groupBuyRepo.SetPaid(buy.ID, mentionee.UserID, paid)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if found {
+			names = append(names, displayName(buy.GroupID, mentionee.UserID))
+		}
+	}
+	if len(names) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.no_buyers"))
+		return
+	}
+	replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.marked", strings.Join(names, "、"), paidText(ctx.Lang, paid)))
+}
+
+func closeGroupBuy(ctx *commandContext, buy repositories.GroupBuy) {
+	if !canManageGroupBuy(ctx.UserID, buy) {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "groupbuy.manage_denied"))
+		return
+	}
+	if _, err := groupBuyRepo.Close(buy.ID); err != nil {
+		log.Println(err)
+		return
+	}
+	now := time.Now()
+	buy.ClosedAt = &now
+	replyGroupBuySummary(ctx.Event.ReplyToken, ctx.Lang, buy)
+}
+
+// handleGroupBuyPostback orders one more of an item, or shows the user's
+// order for op=mine.
+func handleGroupBuyPostback(event *linebot.Event, values url.Values) {
+	groupID := event.Source.GroupID
+	lang := languageOf(groupID)
+	buy, ok := findGroupBuy(event.ReplyToken, lang, groupID, values.Get("id"))
+	if !ok {
+		return
+	}
+	if values.Get("op") == "mine" {
+		replyMyGroupBuyOrder(event.ReplyToken, lang, event.Source.UserID, buy)
+		return
+	}
+	itemID, err := strconv.Atoi(values.Get("item"))
+	if err != nil {
+		log.Printf("invalid group-buy postback: %v", values)
+		return
+	}
+	item, ok := findItem(buy, itemID)
+	if !ok {
+		log.Printf("invalid group-buy postback: %v", values)
+		return
+	}
+	addGroupBuyItem(event.ReplyToken, lang, event.Source.UserID, buy, item)
+}
+
+// startGroupBuyCloser closes group-buys whose deadline has passed, posts
+// the summary to their group and sends the export link to the organizer.
+func startGroupBuyCloser() {
+	go func() {
+		for {
+			closeDueGroupBuys()
+			time.Sleep(groupBuyCloseInterval)
+		}
+	}()
+}
+
+func closeDueGroupBuys() {
+	buys, err := groupBuyRepo.ListDueToClose()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, buy := range buys {
+		closed, err := groupBuyRepo.Close(buy.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !closed {
+			continue
+		}
+		orders, err := groupBuyRepo.ListOrders(buy.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		now := time.Now()
+		buy.ClosedAt = &now
+		lang := languageOf(buy.GroupID)
+		summary := groupBuySummary(lang, buy, orders, "groupbuy.final")
+		if _, err := bot.PushMessage(buy.GroupID, linebot.NewTextMessage(summary)).Do(); err != nil {
+			log.Println(err)
+		}
+		if link, ok := groupBuyExportURL(buy.ID, now); ok {
+			message := linebot.NewTextMessage(i18n.T(lang, "groupbuy.export_link", buy.Title, link))
+			if _, err := bot.PushMessage(buy.OrganizerID, message).Do(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+var groupRepo repositories.GroupsRepository
+
+// defaultGroups are the groups the bot was serving before the registry
+// existed. They are only inserted when missing, so admin edits win.
+var defaultGroups = []repositories.Group{
+	{GroupID: "C193b9f94b6774670be047cf22575d99f", Name: "大一群", Type: repositories.GroupTypeGeneral},
+	{GroupID: "C1ee14832848258d925ab801cb91fd76e", Name: "大二群", Type: repositories.GroupTypeGeneral},
+	{GroupID: "C9fff1abaab5eddda37095a31b11b9335", Name: "大三群", Type: repositories.GroupTypeGeneral},
+	{GroupID: "Cb6cfd28af50d41e8dd69b83efa7a5d26", Name: "北一群", Type: repositories.GroupTypeRegional, Region: "北區"},
+	{GroupID: "Cc36a07572245c408431d11bd7fd94a45", Name: "北二群", Type: repositories.GroupTypeRegional, Region: "北區"},
+	{GroupID: "C70b22d41c71fbccd1f557f6010f1d3e5", Name: "中區群", Type: repositories.GroupTypeRegional, Region: "中區"},
+	{GroupID: "Cff9579c1947754d35387850add5c437e", Name: "南區群", Type: repositories.GroupTypeRegional, Region: "南區"},
+	{GroupID: "C9e940992c239eb57663525cde6b26a6b", Name: "bot 測試群", Type: repositories.GroupTypeTest},
+	{GroupID: "Ca23770eb185ea43e725a71cda54a7e9e", Name: "退休生活", Type: repositories.GroupTypeTest},
+}
+
+var groupTypeNames = map[string]repositories.GroupType{
+	"regional": repositories.GroupTypeRegional,
+	"區域":       repositories.GroupTypeRegional,
+	"general":  repositories.GroupTypeGeneral,
+	"一般":       repositories.GroupTypeGeneral,
+	"test":     repositories.GroupTypeTest,
+	"測試":       repositories.GroupTypeTest,
+	"admin":    repositories.GroupTypeAdmin,
+	"管理":       repositories.GroupTypeAdmin,
+}
+
+func seedGroups() {
+	for _, group := range defaultGroups {
+		if err := groupRepo.Register(group); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// registerGroup records a group the bot has just joined. New groups start
+// as test groups so nothing user-facing fires until an admin classifies them.
+func registerGroup(groupID string) repositories.Group {
+	group := repositories.Group{GroupID: groupID, Type: repositories.GroupTypeTest}
+	if summary, err := bot.GetGroupSummary(groupID).Do(); err != nil {
+		log.Println(err)
+	} else {
+		group.Name = summary.GroupName
+	}
+	if err := groupRepo.Register(group); err != nil {
+		log.Println(err)
+	}
+	return group
+}
+
+func regionalGroups() map[string]string {
+	groups, err := groupRepo.ListByType(repositories.GroupTypeRegional)
+	if err != nil {
+		log.Println(err)
+	}
+	result := make(map[string]string, len(groups))
+	for _, group := range groups {
+		result[group.GroupID] = group.Name
+	}
+	return result
+}
+
+// languageOf is the reply language of a chat: the group's setting, or the
+// default for 1:1 chats and groups without one.
+func languageOf(groupID string) string {
+	if groupID == "" {
+		return i18n.Default
+	}
+	group, err := groupRepo.Get(groupID)
+	if err != nil || group.Language == "" {
+		return i18n.Default
+	}
+	return group.Language
+}
+
+// isTextOnly reports whether the group prefers plain text over Flex cards.
+func isTextOnly(groupID string) bool {
+	if groupID == "" {
+		return false
+	}
+	group, err := groupRepo.Get(groupID)
+	return err == nil && group.TextOnly
+}
+
+func isAdminGroup(groupID string) bool {
+	group, err := groupRepo.Get(groupID)
+	return err == nil && group.Type == repositories.GroupTypeAdmin
+}
+
+func isWelcomeGroup(groupID string) bool {
+	group, err := groupRepo.Get(groupID)
+	if err != nil {
+		if !errors.Is(err, repositories.ErrNotFound) {
+			log.Println(err)
+		}
+		return false
+	}
+	return group.Type == repositories.GroupTypeRegional || group.Type == repositories.GroupTypeGeneral
+}
+
+// groupAdminCommand shows or edits the current group's registry entry.
+func groupAdminCommand(ctx *commandContext) {
+	replyToken, groupID := ctx.Event.ReplyToken, ctx.GroupID
+	keyword, arg := strings.Fields(ctx.Msg)[0], ctx.Args
+
+	if keyword == "群組列表" {
+		groups, err := groupRepo.List()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		lines := make([]string, 0, len(groups))
+		for _, group := range groups {
+			lines = append(lines, fmt.Sprintf("[%s] %s %s", group.Type, group.Name, group.Region))
+		}
+		replyText(replyToken, strings.Join(lines, "\n"))
+		return
+	}
+
+	group, err := groupRepo.Get(groupID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		group = registerGroup(groupID)
+	} else if err != nil {
+		log.Println(err)
+		return
+	}
+
+	switch keyword {
+	case "群組類型":
+		groupType, ok := groupTypeNames[arg]
+		if !ok {
+			replyText(replyToken, i18n.T(ctx.Lang, "group.type_usage"))
+			return
+		}
+		group.Type = groupType
+	case "群組名稱":
+		if arg == "" {
+			replyText(replyToken, i18n.T(ctx.Lang, "group.name_usage"))
+			return
+		}
+		group.Name = arg
+	case "群組地區":
+		group.Region = arg
+	case "群組語言":
+		lang, ok := i18n.Normalize(arg)
+		if !ok {
+			replyText(replyToken, i18n.T(ctx.Lang, "group.language_usage", strings.Join(i18n.Supported(), " / ")))
+			return
+		}
+		group.Language = lang
+	case "群組純文字":
+		switch arg {
+		case "開啟", "on":
+			group.TextOnly = true
+		case "關閉", "off":
+			group.TextOnly = false
+		default:
+			replyText(replyToken, i18n.T(ctx.Lang, "group.text_only_usage"))
+			return
+		}
+	}
+
+	if keyword != "群組資訊" {
+		if err := groupRepo.Update(group); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	lang := group.Language
+	if lang == "" {
+		lang = i18n.Default
+	}
+	textOnly := i18n.T(lang, "group.off")
+	if group.TextOnly {
+		textOnly = i18n.T(lang, "group.on")
+	}
+	replyText(replyToken, i18n.T(lang, "group.info", group.Name, group.Type, group.Region, lang, textOnly, group.GroupID))
+}
+
+func replyText(replyToken, text string) {
+	if _, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage(text)).Do(); err != nil {
+		log.Println(err)
+	}
+}
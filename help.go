@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+)
+
+// replyHelp is only sent in 1:1 chats, so it uses the default language.
+// The buttons keep sending the Chinese keywords the bot listens for.
+func replyHelp(replyToken string) {
+	lang := i18n.Default
+	if _, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage(i18n.T(lang, "help.text")).WithQuickReplies(linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "help.button.catcher"), "一起抓抓樂")),
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "help.button.verify"), "車主認證")),
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "help.button.quiz"), "入群測驗")),
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "help.button.commands"), "?指令")),
+	))).Do(); err != nil {
+		log.Println(err)
+	}
+}
+
+// replyUnsupported answers message types the bot cannot do anything with
+// outside of a flow.
+func replyUnsupported(replyToken string, message linebot.Message) {
+	lang := i18n.Default
+	key := "unsupported.default"
+	switch message.(type) {
+	case *linebot.StickerMessage:
+		key = "unsupported.sticker"
+	case *linebot.ImageMessage:
+		key = "unsupported.image"
+	case *linebot.VideoMessage, *linebot.AudioMessage, *linebot.FileMessage:
+		key = "unsupported.media"
+	}
+	if _, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage(i18n.T(lang, key)).WithQuickReplies(linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "help.button.help"), "說明")),
+	))).Do(); err != nil {
+		log.Println(err)
+	}
+}
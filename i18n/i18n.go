@@ -0,0 +1,89 @@
+// Package i18n holds the bot's reply texts per language. Catalogs are JSON
+// files under locales/ embedded at build time, named after the language
+// tag they serve.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Default is used for 1:1 chats, groups without a language setting and
+// keys missing from another catalog.
+const Default = "zh-TW"
+
+//go:embed locales/*.json
+var files embed.FS
+
+var catalogs = map[string]map[string]string{}
+
+func init() {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		data, err := files.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Errorf("i18n: %s: %w", entry.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+	if _, ok := catalogs[Default]; !ok {
+		panic("i18n: missing default catalog " + Default)
+	}
+}
+
+// Supported lists the available language tags.
+func Supported() []string {
+	result := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		result = append(result, lang)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Normalize maps user input such as "EN", "en-US" or "中文" to a supported
+// tag, reporting false when nothing matches.
+func Normalize(lang string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(lang)) {
+	case "中文", "繁中", "繁體中文", "zh", "zh-tw", "zh_tw", "zh-hant":
+		return Default, true
+	case "英文", "english":
+		return "en", true
+	}
+	for supported := range catalogs {
+		if strings.EqualFold(lang, supported) {
+			return supported, true
+		}
+	}
+	if idx := strings.IndexAny(lang, "-_"); idx > 0 {
+		return Normalize(lang[:idx])
+	}
+	return "", false
+}
+
+// T looks up key in lang's catalog, falling back to the default catalog
+// and then to the key itself, and formats it with args if any.
+func T(lang, key string, args ...interface{}) string {
+	text, ok := catalogs[lang][key]
+	if !ok {
+		text, ok = catalogs[Default][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/flex"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares v, marshalled as indented JSON, with testdata/name.json.
+func golden(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name+".json")
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from golden file:\n%s", path, got)
+	}
+}
+
+func searchResults(n int) []flex.Card {
+	result := make([]flex.Card, 0, n)
+	for idx := 1; idx <= n; idx++ {
+		plate := fmt.Sprintf("ABC-%04d", idx)
+		result = append(result, flex.Card{
+			Bubble: flex.Bubble(nil, flex.Box(flex.Row("車牌號碼", plate)), nil),
+			Alt:    plate,
+		})
+	}
+	return result
+}
+
+// Text-only groups cannot press postback buttons, so every page but the
+// last must end with the typed command for the next one.
+func TestSearchPageTextOnly(t *testing.T) {
+	results := searchResults(2*searchPageSize + 3)
+	pages := map[string][]linebot.SendingMessage{}
+	for page := 1; page <= 3; page++ {
+		cards, header, ok := searchPage(i18n.Default, "1234", results, page)
+		if !ok {
+			t.Fatalf("page %d is missing", page)
+		}
+		messages, _ := flex.Messages(i18n.T(i18n.Default, "card.alt"), cards, flex.MaxMessages-1, true)
+		pages[fmt.Sprint(page)] = append([]linebot.SendingMessage{linebot.NewTextMessage(header)}, messages...)
+	}
+	if _, _, ok := searchPage(i18n.Default, "1234", results, 4); ok {
+		t.Error("page 4 should be past the end")
+	}
+	golden(t, "search_text_only", pages)
+}
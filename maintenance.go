@@ -0,0 +1,481 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/conversation"
+	"github.com/tzuhsitseng/kamiq-bot/flex"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const (
+	maintenanceFlowName   = "maintenance"
+	maintenancePlateKey   = "plate"
+	maintenanceDateKey    = "date"
+	maintenanceMileageKey = "mileage"
+	maintenanceItemsKey   = "items"
+	maintenanceCostKey    = "cost"
+	maintenanceShopKey    = "shop"
+	maintenanceItemsLimit = 100
+	maintenanceShopLimit  = 30
+	maintenanceMaxMileage = 999999
+	maintenanceDateLayout = "2006/01/02"
+
+	// The KamiQ service schedule: every 12 months or 15000 km, whichever
+	// comes first. MAINTENANCE_INTERVAL_MONTHS and MAINTENANCE_INTERVAL_KM
+	// override it.
+	defaultMaintenanceMonths = 12
+	defaultMaintenanceKm     = 15000
+
+	// Reminders go out this long, or this many kilometres, before the next
+	// service is due.
+	maintenanceReminderLead   = 14 * 24 * time.Hour
+	maintenanceReminderLeadKm = 500
+	maintenanceReminderCheck  = time.Hour
+
+	// maintenanceMinSpan is the shortest history mileage is extrapolated
+	// from; shorter spans give wild estimates.
+	maintenanceMinSpan = 30 * 24 * time.Hour
+)
+
+var maintenanceRepo repositories.MaintenanceRepository
+
+var maintenanceDateLayouts = []string{"2006/01/02", "2006-01-02", "2006/1/2", "2006-1-2"}
+var shortMaintenanceDateLayouts = []string{"01/02", "1/2", "01-02", "1-2"}
+
+// maintenanceDraft carries the plates the user registered, so the flow can
+// skip the plate question when there is only one.
+type maintenanceDraft struct {
+	Plates []string
+}
+
+func draftPlates(s *conversation.Session) []string {
+	if draft, ok := s.Data.(*maintenanceDraft); ok {
+		return draft.Plates
+	}
+	return nil
+}
+
+func maintenanceInterval() (months, km int) {
+	months, km = defaultMaintenanceMonths, defaultMaintenanceKm
+	if v, err := strconv.Atoi(os.Getenv("MAINTENANCE_INTERVAL_MONTHS")); err == nil && v > 0 {
+		months = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("MAINTENANCE_INTERVAL_KM")); err == nil && v > 0 {
+		km = v
+	}
+	return months, km
+}
+
+// parseServiceDate accepts "今天", full dates and "01/02" style dates, which
+// mean the latest such day that is not in the future.
+func parseServiceDate(text string) (time.Time, error) {
+	now := time.Now().In(clubLocation)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, clubLocation)
+	text = strings.TrimSpace(text)
+	if text == "今天" || strings.EqualFold(text, "today") {
+		return today, nil
+	}
+	for _, layout := range maintenanceDateLayouts {
+		if t, err := time.ParseInLocation(layout, text, clubLocation); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range shortMaintenanceDateLayouts {
+		if t, err := time.ParseInLocation(layout, text, clubLocation); err == nil {
+			t = t.AddDate(today.Year(), 0, 0)
+			if t.After(today) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unrecognized date")
+}
+
+func formatServiceDate(t time.Time) string {
+	return t.In(clubLocation).Format(maintenanceDateLayout)
+}
+
+// parseAmount reads whole numbers such as "1,500", "$1500" or "1500元".
+func parseAmount(text string) (int, error) {
+	text = strings.NewReplacer(",", "", "$", "", "元", "", "NT", "").Replace(strings.TrimSpace(text))
+	return strconv.Atoi(strings.TrimSpace(text))
+}
+
+func maintenanceFlow() *conversation.Flow {
+	lang := i18n.Default
+	return &conversation.Flow{
+		Name:      maintenanceFlowName,
+		Lang:      lang,
+		AckPrefix: i18n.T(lang, "maintenance.ack"),
+		Steps: []conversation.Step{
+			{
+				Key:     maintenancePlateKey,
+				Prompt:  i18n.T(lang, "maintenance.plate_prompt"),
+				Options: draftPlates,
+				Skip: func(s *conversation.Session) bool {
+					return len(draftPlates(s)) == 1
+				},
+			},
+			{
+				Key:    maintenanceDateKey,
+				Prompt: i18n.T(lang, "maintenance.date_prompt"),
+				QuickReplies: []*linebot.QuickReplyButton{
+					linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "maintenance.today"), "今天")),
+				},
+				Validate: func(_ *conversation.Session, input string) (string, error) {
+					t, err := parseServiceDate(input)
+					if err != nil {
+						return "", conversation.ErrInvalid(i18n.T(lang, "maintenance.date_invalid"))
+					}
+					if t.After(time.Now()) {
+						return "", conversation.ErrInvalid(i18n.T(lang, "maintenance.date_future"))
+					}
+					return formatServiceDate(t), nil
+				},
+			},
+			{
+				Key:    maintenanceMileageKey,
+				Prompt: i18n.T(lang, "maintenance.mileage_prompt"),
+				Validate: func(_ *conversation.Session, input string) (string, error) {
+					mileage, err := parseAmount(strings.TrimSuffix(strings.TrimSpace(input), "km"))
+					if err != nil || mileage <= 0 || mileage > maintenanceMaxMileage {
+						return "", conversation.ErrInvalid(i18n.T(lang, "maintenance.mileage_invalid"))
+					}
+					return strconv.Itoa(mileage), nil
+				},
+			},
+			{
+				Key:    maintenanceItemsKey,
+				Prompt: i18n.T(lang, "maintenance.items_prompt"),
+				QuickReplies: []*linebot.QuickReplyButton{
+					linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "maintenance.item.regular"), i18n.T(lang, "maintenance.item.regular"))),
+					linebot.NewQuickReplyButton("", linebot.NewMessageAction(i18n.T(lang, "maintenance.item.oil"), i18n.T(lang, "maintenance.item.oil"))),
+				},
+				Validate: func(_ *conversation.Session, input string) (string, error) {
+					if utf8.RuneCountInString(input) > maintenanceItemsLimit {
+						return "", conversation.ErrInvalid(i18n.T(lang, "maintenance.too_long", maintenanceItemsLimit))
+					}
+					return input, nil
+				},
+			},
+			{
+				Key:       maintenanceCostKey,
+				Prompt:    i18n.T(lang, "maintenance.cost_prompt"),
+				Optional:  true,
+				SkipValue: "0",
+				Validate: func(_ *conversation.Session, input string) (string, error) {
+					cost, err := parseAmount(input)
+					if err != nil || cost < 0 {
+						return "", conversation.ErrInvalid(i18n.T(lang, "maintenance.cost_invalid"))
+					}
+					return strconv.Itoa(cost), nil
+				},
+			},
+			{
+				Key:      maintenanceShopKey,
+				Prompt:   i18n.T(lang, "maintenance.shop_prompt"),
+				Optional: true,
+				Validate: func(_ *conversation.Session, input string) (string, error) {
+					if utf8.RuneCountInString(input) > maintenanceShopLimit {
+						return "", conversation.ErrInvalid(i18n.T(lang, "maintenance.too_long", maintenanceShopLimit))
+					}
+					return input, nil
+				},
+			},
+		},
+		Summary: func(s *conversation.Session) []linebot.SendingMessage {
+			messages, _ := flex.Messages(i18n.T(lang, "maintenance.confirm_alt"),
+				[]flex.Card{makeMaintenanceCard(lang, maintenanceFromSession(s))}, 1, false)
+			return messages
+		},
+		Complete:    saveMaintenance,
+		Restart:     beginMaintenanceFlow,
+		CancelText:  i18n.T(lang, "maintenance.cancelled"),
+		TimeoutText: i18n.T(lang, "maintenance.timeout"),
+	}
+}
+
+// registeredPlates lists the user's plates from their catcher registration;
+// maintenance records are kept per plate.
+func registeredPlates(userID string) ([]string, error) {
+	rows, err := catcherRepo.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	var plates []string
+	for _, row := range rows {
+		if row.LicensePlateNumber != "" && !containsString(plates, row.LicensePlateNumber) {
+			plates = append(plates, row.LicensePlateNumber)
+		}
+	}
+	return plates, nil
+}
+
+// startMaintenanceFlow begins logging a service, or asks whether to resume
+// one already in progress.
+func startMaintenanceFlow(replyToken, userID string) {
+	if s, ok := flows.Active(userID); ok && s.Flow.Name == maintenanceFlowName {
+		flows.Start(replyToken, userID, maintenanceFlowName, s.Data)
+		return
+	}
+	beginMaintenanceFlow(replyToken, userID)
+}
+
+func beginMaintenanceFlow(replyToken, userID string) {
+	plates, err := registeredPlates(userID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(plates) == 0 {
+		replyText(replyToken, i18n.T(i18n.Default, "maintenance.unregistered"))
+		return
+	}
+	flows.StartWithPrefix(replyToken, userID, maintenanceFlowName, &maintenanceDraft{Plates: plates}, "")
+}
+
+func maintenanceFromSession(s *conversation.Session) repositories.MaintenanceRecord {
+	plate := s.Get(maintenancePlateKey)
+	if plates := draftPlates(s); plate == "" && len(plates) > 0 {
+		plate = plates[0]
+	}
+	servicedAt, _ := time.ParseInLocation(maintenanceDateLayout, s.Get(maintenanceDateKey), clubLocation)
+	mileage, _ := strconv.Atoi(s.Get(maintenanceMileageKey))
+	cost, _ := strconv.Atoi(s.Get(maintenanceCostKey))
+	return repositories.MaintenanceRecord{
+		UserID:             s.UserID,
+		LicensePlateNumber: plate,
+		ServicedAt:         servicedAt,
+		Mileage:            mileage,
+		Items:              s.Get(maintenanceItemsKey),
+		Cost:               cost,
+		Shop:               s.Get(maintenanceShopKey),
+	}
+}
+
+func saveMaintenance(replyToken string, s *conversation.Session) {
+	record := maintenanceFromSession(s)
+	id, err := maintenanceRepo.Create(record)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	record.ID = id
+
+	records, err := maintenanceRepo.ListByUser(s.UserID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	due := nextService(plateRecords(records, record.LicensePlateNumber), time.Now())
+	replyText(replyToken, i18n.T(i18n.Default, "maintenance.saved", record.ID,
+		formatServiceDate(due.Date), due.Mileage))
+}
+
+// maintenanceCommand runs "保養" for the history, "保養 新增" to log a
+// service and "保養 刪除 編號" to remove a mistaken record. Records are
+// private, so the command only works in 1:1 chat.
+func maintenanceCommand(ctx *commandContext) {
+	if ctx.GroupID != "" {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "maintenance.private"))
+		return
+	}
+	sub, rest := ctx.Args, ""
+	if idx := strings.IndexAny(ctx.Args, " \n"); idx >= 0 {
+		sub, rest = ctx.Args[:idx], strings.TrimSpace(ctx.Args[idx+1:])
+	}
+
+	switch sub {
+	case "", "紀錄", "記錄":
+		replyMaintenanceHistory(ctx.Event.ReplyToken, ctx.Lang, ctx.UserID)
+	case "新增":
+		startMaintenanceFlow(ctx.Event.ReplyToken, ctx.UserID)
+	case "刪除":
+		id, err := strconv.Atoi(strings.TrimPrefix(rest, "#"))
+		if err != nil {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "maintenance.usage"))
+			return
+		}
+		deleted, err := maintenanceRepo.Delete(ctx.UserID, id)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if !deleted {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "maintenance.not_found", rest))
+			return
+		}
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "maintenance.deleted", id))
+	default:
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "maintenance.usage"))
+	}
+}
+
+func plateRecords(records []repositories.MaintenanceRecord, plate string) []repositories.MaintenanceRecord {
+	result := make([]repositories.MaintenanceRecord, 0)
+	for _, record := range records {
+		if record.LicensePlateNumber == plate {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// serviceDue is when the next service falls due by time and by mileage.
+// Estimated is the current mileage extrapolated from the history, or 0
+// when there is too little history to tell.
+type serviceDue struct {
+	Date      time.Time
+	Mileage   int
+	Estimated int
+}
+
+// nextService works out the next service from one plate's records, newest
+// first.
+func nextService(records []repositories.MaintenanceRecord, now time.Time) serviceDue {
+	if len(records) == 0 {
+		return serviceDue{}
+	}
+	months, km := maintenanceInterval()
+	latest := records[0]
+	due := serviceDue{
+		Date:    latest.ServicedAt.AddDate(0, months, 0),
+		Mileage: latest.Mileage + km,
+	}
+
+	oldest := records[len(records)-1]
+	span := latest.ServicedAt.Sub(oldest.ServicedAt)
+	if span >= maintenanceMinSpan && latest.Mileage > oldest.Mileage {
+		perDay := float64(latest.Mileage-oldest.Mileage) / span.Hours() * 24
+		elapsed := now.Sub(latest.ServicedAt).Hours() / 24
+		due.Estimated = latest.Mileage + int(perDay*elapsed)
+	}
+	return due
+}
+
+func replyMaintenanceHistory(replyToken, lang, userID string) {
+	records, err := maintenanceRepo.ListByUser(userID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(records) == 0 {
+		replyText(replyToken, i18n.T(lang, "maintenance.none"))
+		return
+	}
+
+	var plates []string
+	for _, record := range records {
+		if !containsString(plates, record.LicensePlateNumber) {
+			plates = append(plates, record.LicensePlateNumber)
+		}
+	}
+	cards := make([]flex.Card, 0, len(records)+len(plates))
+	for _, plate := range plates {
+		history := plateRecords(records, plate)
+		cards = append(cards, makeNextServiceCard(lang, plate, history))
+		for _, record := range history {
+			cards = append(cards, makeMaintenanceCard(lang, record))
+		}
+	}
+	replyCarousel(replyToken, "", i18n.T(lang, "maintenance.history"), cards)
+}
+
+func makeNextServiceCard(lang, plate string, history []repositories.MaintenanceRecord) flex.Card {
+	due := nextService(history, time.Now())
+	latest := history[0]
+	body := []linebot.FlexComponent{
+		flex.Title(i18n.T(lang, "maintenance.next_title", plate)),
+		flex.Row(i18n.T(lang, "maintenance.last"), fmt.Sprintf("%s / %d km", formatServiceDate(latest.ServicedAt), latest.Mileage)),
+		flex.Row(i18n.T(lang, "maintenance.due_date"), formatServiceDate(due.Date)),
+		flex.Row(i18n.T(lang, "maintenance.due_mileage"), fmt.Sprintf("%d km", due.Mileage)),
+	}
+	if due.Estimated > 0 {
+		body = append(body, flex.Row(i18n.T(lang, "maintenance.estimated"), fmt.Sprintf("%d km", due.Estimated)))
+	}
+	months, km := maintenanceInterval()
+	body = append(body, flex.Text(i18n.T(lang, "maintenance.hint", months, km)))
+	return flex.Card{
+		Bubble: flex.Bubble(nil, flex.Box(body...),
+			flex.Buttons(linebot.NewMessageAction(i18n.T(lang, "maintenance.add"), "?保養 新增"))),
+		Alt: i18n.T(lang, "maintenance.next_alt", plate, formatServiceDate(due.Date), due.Mileage),
+	}
+}
+
+func makeMaintenanceCard(lang string, record repositories.MaintenanceRecord) flex.Card {
+	body := []linebot.FlexComponent{
+		flex.Title(formatServiceDate(record.ServicedAt)),
+		flex.Row(i18n.T(lang, "maintenance.plate"), record.LicensePlateNumber),
+		flex.Row(i18n.T(lang, "maintenance.mileage"), fmt.Sprintf("%d km", record.Mileage)),
+		flex.Row(i18n.T(lang, "maintenance.items"), record.Items),
+	}
+	if record.Cost > 0 {
+		body = append(body, flex.Row(i18n.T(lang, "maintenance.cost"), fmt.Sprintf("$%d", record.Cost)))
+	}
+	if record.Shop != "" {
+		body = append(body, flex.Row(i18n.T(lang, "maintenance.shop"), record.Shop))
+	}
+	if record.ID > 0 {
+		body = append(body, flex.Text(i18n.T(lang, "maintenance.record_hint", record.ID)))
+	}
+	return flex.Card{
+		Bubble: flex.Bubble(nil, flex.Box(body...), nil),
+		Alt:    fmt.Sprintf("%s %d km %s", formatServiceDate(record.ServicedAt), record.Mileage, record.Items),
+	}
+}
+
+// startMaintenanceReminders pushes a reminder once per logged service when
+// the next one is near, by date or by the estimated mileage. Pushes only
+// reach users who have added the bot as a friend.
+func startMaintenanceReminders() {
+	go func() {
+		for {
+			remindMaintenance()
+			time.Sleep(maintenanceReminderCheck)
+		}
+	}()
+}
+
+func remindMaintenance() {
+	latest, err := maintenanceRepo.ListLatestUnreminded()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	now := time.Now()
+	for _, record := range latest {
+		records, err := maintenanceRepo.ListByUser(record.UserID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		due := nextService(plateRecords(records, record.LicensePlateNumber), now)
+		byDate := now.After(due.Date.Add(-maintenanceReminderLead))
+		byMileage := due.Estimated > 0 && due.Estimated >= due.Mileage-maintenanceReminderLeadKm
+		if !byDate && !byMileage {
+			continue
+		}
+
+		message := linebot.NewTextMessage(i18n.T(i18n.Default, "maintenance.reminder", record.LicensePlateNumber,
+			formatServiceDate(record.ServicedAt), record.Mileage, formatServiceDate(due.Date), due.Mileage))
+		// A failed push is retried on the next run.
+		if _, err := bot.PushMessage(record.UserID, message).Do(); err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := maintenanceRepo.MarkReminded(record.ID); err != nil {
+			log.Println(err)
+		}
+	}
+}
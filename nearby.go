@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/geo"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const maxNearbyCatchers = 10
+
+// nearbyCommand lists catchers who frequent a district: the one given as
+// argument, or otherwise the caller's own registered district. "位置"
+// searches around a shared location instead, and "開啟" / "關閉" opt in to
+// or out of location searches.
+func nearbyCommand(ctx *commandContext) {
+	switch ctx.Args {
+	case "位置":
+		requestNearbyLocation(ctx)
+		return
+	case "開啟":
+		setDiscoverable(ctx, true)
+		return
+	case "關閉":
+		setDiscoverable(ctx, false)
+		return
+	}
+
+	city, district := "", ""
+	if ctx.Args != "" {
+		city, district = geo.ParseAddress(ctx.Args)
+		if city == "" {
+			if county, ok := geo.FindCounty(ctx.Args); ok {
+				city = county.Name
+			} else if geo.ValidDistrict(ctx.Args) {
+				district = geo.Normalize(ctx.Args)
+			}
+		}
+	} else {
+		rows, err := catcherRepo.ListByUser(ctx.UserID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for _, row := range rows {
+			if row.City != "" {
+				city, district = row.City, row.District
+				break
+			}
+		}
+	}
+	if city == "" && district == "" {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "nearby.usage"))
+		return
+	}
+
+	rows, err := catcherRepo.ListByArea(city, district)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	others := make([]repositories.Catcher, 0, len(rows))
+	users := map[string]bool{}
+	for _, row := range rows {
+		if row.UserID == ctx.UserID {
+			continue
+		}
+		if !users[row.UserID] && len(users) >= maxNearbyCatchers {
+			continue
+		}
+		users[row.UserID] = true
+		others = append(others, row)
+	}
+
+	area := city + district
+	if len(others) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "nearby.none", area))
+		return
+	}
+	replyCarousel(ctx.Event.ReplyToken, ctx.GroupID, i18n.T(ctx.Lang, "nearby.alt", area), makeCatcherContents(ctx.Lang, others),
+		linebot.NewTextMessage(i18n.T(ctx.Lang, "nearby.header", area, len(users))))
+}
+
+const (
+	defaultNearbyRadius = 15.0
+	nearbyDistanceStep  = 5.0
+	nearbyRequestTTL    = 5 * time.Minute
+)
+
+// nearbyRequests remembers "?附近 位置" in groups, keyed by group and user,
+// so only the asker's next shared location triggers a search.
+var nearbyRequests = sync.Map{}
+
+type nearbyCatcher struct {
+	repositories.Catcher
+	Distance float64
+}
+
+func nearbyRadius() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("NEARBY_RADIUS_KM"), 64); err == nil && v > 0 {
+		return v
+	}
+	return defaultNearbyRadius
+}
+
+func nearbyRequestKey(groupID, userID string) string {
+	return groupID + "|" + userID
+}
+
+func requestNearbyLocation(ctx *commandContext) {
+	if ctx.GroupID != "" {
+		nearbyRequests.Store(nearbyRequestKey(ctx.GroupID, ctx.UserID), time.Now())
+	}
+	if _, err := bot.ReplyMessage(ctx.Event.ReplyToken, linebot.NewTextMessage(i18n.T(ctx.Lang, "nearby.share_prompt")).
+		WithQuickReplies(linebot.NewQuickReplyItems(
+			linebot.NewQuickReplyButton("", linebot.NewLocationAction(i18n.T(ctx.Lang, "nearby.share_button"))),
+		))).Do(); err != nil {
+		log.Println(err)
+	}
+}
+
+// takeNearbyRequest reports whether the user asked for a location search in
+// the group recently, consuming the request.
+func takeNearbyRequest(groupID, userID string) bool {
+	v, ok := nearbyRequests.LoadAndDelete(nearbyRequestKey(groupID, userID))
+	return ok && time.Since(v.(time.Time)) < nearbyRequestTTL
+}
+
+func setDiscoverable(ctx *commandContext, discoverable bool) {
+	registered, err := catcherRepo.SetDiscoverable(ctx.UserID, discoverable)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !registered {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "nearby.unregistered"))
+		return
+	}
+	if discoverable {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "nearby.enabled"))
+	} else {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "nearby.disabled"))
+	}
+}
+
+// findNearbyCatchers returns opted-in catchers within the radius, closest
+// first, one entry per user. Catchers without coordinates are placed at the
+// centre of their county.
+func findNearbyCatchers(lat, lng float64, excludeUserID string) ([]nearbyCatcher, error) {
+	rows, err := catcherRepo.ListDiscoverable()
+	if err != nil {
+		return nil, err
+	}
+
+	radius := nearbyRadius()
+	closest := map[string]nearbyCatcher{}
+	for _, row := range rows {
+		if row.UserID == excludeUserID {
+			continue
+		}
+		var rowLat, rowLng float64
+		if row.Latitude != nil && row.Longitude != nil {
+			rowLat, rowLng = *row.Latitude, *row.Longitude
+		} else if county, ok := geo.FindCounty(row.City); ok {
+			rowLat, rowLng = county.Latitude, county.Longitude
+		} else {
+			continue
+		}
+		distance := geo.Distance(lat, lng, rowLat, rowLng)
+		if distance > radius {
+			continue
+		}
+		if current, ok := closest[row.UserID]; !ok || distance < current.Distance {
+			closest[row.UserID] = nearbyCatcher{Catcher: row, Distance: distance}
+		}
+	}
+
+	result := make([]nearbyCatcher, 0, len(closest))
+	for _, catcher := range closest {
+		result = append(result, catcher)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Distance != result[j].Distance {
+			return result[i].Distance < result[j].Distance
+		}
+		return result[i].UserID < result[j].UserID
+	})
+	if len(result) > maxNearbyCatchers {
+		result = result[:maxNearbyCatchers]
+	}
+	return result, nil
+}
+
+// coarseDistance rounds up to the next 5 km so nobody's position can be
+// triangulated from repeated searches.
+func coarseDistance(lang string, distance float64) string {
+	return i18n.T(lang, "nearby.distance", math.Max(1, math.Ceil(distance/nearbyDistanceStep))*nearbyDistanceStep)
+}
+
+func replyNearbyLocation(replyToken, groupID, userID string, location *linebot.LocationMessage) {
+	lang := languageOf(groupID)
+	if !hasRole(userID, "", repositories.RoleMember) {
+		replyText(replyToken, permissionDenied(lang, repositories.RoleMember))
+		return
+	}
+
+	lat, lng := geo.Coarsen(location.Latitude, location.Longitude)
+	nearby, err := findNearbyCatchers(lat, lng, userID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(nearby) == 0 {
+		replyText(replyToken, i18n.T(lang, "nearby.location_none", nearbyRadius()))
+		return
+	}
+
+	lines := []string{i18n.T(lang, "nearby.location_header")}
+	rows := make([]repositories.Catcher, 0, len(nearby))
+	for idx, catcher := range nearby {
+		lines = append(lines, fmt.Sprintf("%d. %s (%s%s) %s", idx+1, catcher.UserName, catcher.City, catcher.District, coarseDistance(lang, catcher.Distance)))
+		rows = append(rows, catcher.Catcher)
+	}
+	replyCarousel(replyToken, groupID, i18n.T(lang, "nearby.location_alt"), makeCatcherContents(lang, rows),
+		linebot.NewTextMessage(strings.Join(lines, "\n")))
+}
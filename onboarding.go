@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const rulesURL = "https://kamiq.club/news?hid=498&nid=214"
+
+var onboardingRepo repositories.OnboardingsRepository
+
+// quizQuestion holds catalog keys rather than texts, so the quiz follows
+// the reader's language.
+type quizQuestion struct {
+	Question string
+	Options  []string
+	Answer   int
+}
+
+var rulesQuiz = []quizQuestion{
+	{
+		Question: "quiz.q1",
+		Options:  []string{"quiz.q1.a1", "quiz.q1.a2", "quiz.q1.a3"},
+		Answer:   0,
+	},
+	{
+		Question: "quiz.q2",
+		Options:  []string{"quiz.q2.a1", "quiz.q2.a2", "quiz.q2.a3"},
+		Answer:   0,
+	},
+	{
+		Question: "quiz.q3",
+		Options:  []string{"quiz.q3.a1", "quiz.q3.a2"},
+		Answer:   1,
+	},
+}
+
+// The quiz runs in 1:1 chats, which use the default language.
+func startQuiz(replyToken string) {
+	lang := i18n.Default
+	if _, err := bot.ReplyMessage(replyToken,
+		linebot.NewTextMessage(i18n.T(lang, "quiz.intro", rulesURL, len(rulesQuiz))),
+		quizMessage(lang, 0),
+	).Do(); err != nil {
+		log.Println(err)
+	}
+}
+
+// pushQuizInvite invites a new member to the quiz. It only reaches people
+// who have already added the bot as a friend, so failures are expected.
+func pushQuizInvite(userID string) {
+	if completed, err := onboardingRepo.IsCompleted(userID); err != nil || completed {
+		return
+	}
+	lang := i18n.Default
+	start := i18n.T(lang, "quiz.start")
+	if _, err := bot.PushMessage(userID, linebot.NewTextMessage(
+		i18n.T(lang, "quiz.invite"),
+	).WithQuickReplies(linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction(start, "action=quiz&q=start", "", start)),
+	))).Do(); err != nil {
+		log.Printf("quiz invite to %s: %v", userID, err)
+	}
+}
+
+func quizMessage(lang string, idx int) linebot.SendingMessage {
+	q := rulesQuiz[idx]
+	buttons := make([]*linebot.QuickReplyButton, 0, len(q.Options))
+	for optIdx, option := range q.Options {
+		data := fmt.Sprintf("action=quiz&q=%d&a=%d", idx, optIdx)
+		label := i18n.T(lang, option)
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, data, "", label)))
+	}
+	return linebot.NewTextMessage(i18n.T(lang, "quiz.question", idx+1, i18n.T(lang, q.Question))).
+		WithQuickReplies(linebot.NewQuickReplyItems(buttons...))
+}
+
+func handleQuizPostback(event *linebot.Event, values url.Values) {
+	if values.Get("q") == "start" {
+		startQuiz(event.ReplyToken)
+		return
+	}
+
+	idx, err := strconv.Atoi(values.Get("q"))
+	if err != nil || idx < 0 || idx >= len(rulesQuiz) {
+		return
+	}
+	answer, err := strconv.Atoi(values.Get("a"))
+	if err != nil {
+		return
+	}
+	lang := i18n.Default
+
+	if answer != rulesQuiz[idx].Answer {
+		if _, err := bot.ReplyMessage(event.ReplyToken,
+			linebot.NewTextMessage(i18n.T(lang, "quiz.wrong", rulesURL)),
+			quizMessage(lang, idx),
+		).Do(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	if idx+1 < len(rulesQuiz) {
+		if _, err := bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage(i18n.T(lang, "quiz.correct")), quizMessage(lang, idx+1)).Do(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	if err := onboardingRepo.Complete(event.Source.UserID); err != nil {
+		log.Println(err)
+		return
+	}
+	replyText(event.ReplyToken, i18n.T(lang, "quiz.completed"))
+}
+
+// unreadRulesCommand lists the group's members who have not finished the
+// quiz. Only members the bot saw joining are known to it.
+func unreadRulesCommand(ctx *commandContext) {
+	replyToken, groupID := ctx.Event.ReplyToken, ctx.GroupID
+
+	members, err := memberRepo.ListActive(groupID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	userIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	completed, err := onboardingRepo.FilterCompleted(userIDs)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	names := make([]string, 0)
+	for _, id := range userIDs {
+		if completed[id] {
+			continue
+		}
+		if profile, err := groupMemberProfile(groupID, id); err == nil {
+			names = append(names, profile.DisplayName)
+		}
+	}
+	if len(names) == 0 {
+		replyText(replyToken, i18n.T(ctx.Lang, "rules.all_read"))
+		return
+	}
+	replyText(replyToken, i18n.T(ctx.Lang, "rules.unread", len(names), strings.Join(names, "\n")))
+}
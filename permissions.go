@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+var roleRepo repositories.RolesRepository
+
+var roles = []repositories.Role{
+	repositories.RoleOwner,
+	repositories.RoleAdmin,
+	repositories.RoleVerified,
+	repositories.RoleMember,
+	repositories.RoleGuest,
+}
+
+// parseRole accepts a role's ID or its name in any catalog, so "admin",
+// "管理員" and "Admin" all work whatever the group's language.
+func parseRole(name string) (repositories.Role, bool) {
+	for _, role := range roles {
+		if strings.EqualFold(name, string(role)) {
+			return role, true
+		}
+		for _, lang := range i18n.Supported() {
+			if strings.EqualFold(name, roleName(lang, role)) {
+				return role, true
+			}
+		}
+	}
+	return "", false
+}
+
+// roleOf works out a user's effective role. Owners and admins come from
+// the database or the OWNER_USER_IDS / ADMIN_USER_IDS bootstrap lists,
+// verified is an approved owner verification, and member means being in one
+// of the club's groups. A role stored in the database is explicit, so a
+// user demoted to guest stays a guest even while in a club group.
+func roleOf(userID, groupID string) repositories.Role {
+	role, stored := repositories.RoleGuest, false
+	if r, err := roleRepo.Get(userID); err == nil {
+		role, stored = r, true
+	} else if !errors.Is(err, repositories.ErrNotFound) {
+		log.Println(err)
+	}
+
+	if inUserIDList("OWNER_USER_IDS", userID) {
+		return repositories.RoleOwner
+	}
+	if inUserIDList("ADMIN_USER_IDS", userID) && role.Level() < repositories.RoleAdmin.Level() {
+		return repositories.RoleAdmin
+	}
+	if role.Level() >= repositories.RoleVerified.Level() || (stored && role == repositories.RoleGuest) {
+		return role
+	}
+
+	if verified, err := verificationRepo.IsVerified(userID); err != nil {
+		log.Println(err)
+	} else if verified {
+		return repositories.RoleVerified
+	}
+	if stored {
+		return role
+	}
+
+	if groupID != "" && isWelcomeGroup(groupID) {
+		return repositories.RoleMember
+	}
+	for gid := range regionalGroups() {
+		if _, err := groupMemberProfile(gid, userID); err == nil {
+			return repositories.RoleMember
+		}
+	}
+	return role
+}
+
+func hasRole(userID, groupID string, required repositories.Role) bool {
+	if required.Level() == repositories.RoleGuest.Level() {
+		return true
+	}
+	return roleOf(userID, groupID).Level() >= required.Level()
+}
+
+func inUserIDList(env, userID string) bool {
+	for _, id := range strings.Split(os.Getenv(env), ",") {
+		if id != "" && strings.TrimSpace(id) == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func roleName(lang string, role repositories.Role) string {
+	return i18n.T(lang, "role."+string(role))
+}
+
+func permissionDenied(lang string, required repositories.Role) string {
+	return i18n.T(lang, "role.denied", roleName(lang, required))
+}
+
+// roleCommand shows the caller's role, or with a role name and @mentions
+// assigns that role. Only owners can hand out admin or owner.
+func roleCommand(ctx *commandContext) {
+	fields := strings.Fields(ctx.Args)
+	callerRole := roleOf(ctx.UserID, ctx.GroupID)
+	if len(fields) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "role.current", roleName(ctx.Lang, callerRole)))
+		return
+	}
+
+	role, ok := parseRole(fields[0])
+	if !ok {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "role.usage"))
+		return
+	}
+	if callerRole.Level() < repositories.RoleAdmin.Level() ||
+		(role.Level() >= repositories.RoleAdmin.Level() && callerRole != repositories.RoleOwner) {
+		replyText(ctx.Event.ReplyToken, permissionDenied(ctx.Lang, repositories.RoleOwner))
+		return
+	}
+	if ctx.Mention == nil || len(ctx.Mention.Mentionees) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "role.mention"))
+		return
+	}
+
+	count := 0
+	for _, mentionee := range ctx.Mention.Mentionees {
+		if mentionee.UserID == "" {
+			continue
+		}
+		if err := roleRepo.Set(mentionee.UserID, role); err != nil {
+			log.Println(err)
+			continue
+		}
+		go linkRichMenu(mentionee.UserID)
+		count++
+	}
+	replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "role.assigned", count, roleName(ctx.Lang, role)))
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/flex"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const (
+	maxPollOptions      = 10
+	maxPollOptionLength = 20
+	pollCloseInterval   = time.Minute
+)
+
+var pollRepo repositories.PollsRepository
+
+// pollCommand runs "投票 題目 / 選項A / 選項B [/ 截止 時間]" to start a
+// poll, and "投票 [列表]", "投票 選 編號 選項號碼", "投票 結果 編號",
+// "投票 結束 編號" to follow up. Voting by text is for text-only groups.
+// Anything that does not match a follow-up exactly, such as a question that
+// starts with 選, starts a poll.
+func pollCommand(ctx *commandContext) {
+	sub, rest := ctx.Args, ""
+	if idx := strings.IndexAny(ctx.Args, " \n"); idx >= 0 {
+		sub, rest = ctx.Args[:idx], strings.TrimSpace(ctx.Args[idx+1:])
+	}
+	numbers, ok := pollNumbers(rest)
+
+	switch {
+	case sub == "" || (sub == "列表" && rest == ""):
+		listPolls(ctx)
+	case sub == "選" && ok && len(numbers) == 2:
+		poll, ok := findPoll(ctx.Event.ReplyToken, ctx.Lang, ctx.GroupID, strings.Fields(rest)[0])
+		if !ok {
+			return
+		}
+		if numbers[1] < 1 || numbers[1] > len(poll.Options) {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.invalid_option", len(poll.Options)))
+			return
+		}
+		castVote(ctx.Event.ReplyToken, ctx.Lang, ctx.UserID, poll, numbers[1]-1)
+	case (sub == "結果" || sub == "結束") && ok && len(numbers) == 1:
+		poll, ok := findPoll(ctx.Event.ReplyToken, ctx.Lang, ctx.GroupID, rest)
+		if !ok {
+			return
+		}
+		if sub == "結束" {
+			closePoll(ctx, poll)
+			return
+		}
+		replyPollResult(ctx.Event.ReplyToken, ctx.Lang, poll)
+	default:
+		createPoll(ctx)
+	}
+}
+
+// pollNumbers parses whitespace separated numbers, allowing a leading # as
+// poll IDs are shown with one, and reports false if any field is not one.
+func pollNumbers(text string) ([]int, bool) {
+	fields := strings.Fields(text)
+	numbers := make([]int, 0, len(fields))
+	for _, field := range fields {
+		number, err := strconv.Atoi(strings.TrimPrefix(field, "#"))
+		if err != nil {
+			return nil, false
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, true
+}
+
+func createPoll(ctx *commandContext) {
+	args, deadline, found := cutDeadline(ctx.Args)
+	var closesAt *time.Time
+	if found {
+		t, err := parseClubTime(deadline)
+		if err != nil {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.invalid_time", deadline))
+			return
+		}
+		if t.Before(time.Now()) {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.past_time"))
+			return
+		}
+		closesAt = &t
+	}
+
+	fields := splitFields(strings.ReplaceAll(args, "/", "|"))
+	if len(fields) < 3 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.usage"))
+		return
+	}
+	if len(fields)-1 > maxPollOptions {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.too_many", maxPollOptions))
+		return
+	}
+
+	poll := repositories.Poll{
+		GroupID:   ctx.GroupID,
+		Question:  fields[0],
+		CreatorID: ctx.UserID,
+		ClosesAt:  closesAt,
+	}
+	seen := map[string]bool{}
+	for idx, text := range fields[1:] {
+		if utf8.RuneCountInString(text) > maxPollOptionLength {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.option_too_long", maxPollOptionLength))
+			return
+		}
+		if seen[text] {
+			replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.duplicate", text))
+			return
+		}
+		seen[text] = true
+		poll.Options = append(poll.Options, repositories.PollOption{Position: idx, Text: text})
+	}
+
+	id, err := pollRepo.Create(poll)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	poll.ID = id
+	replyCarousel(ctx.Event.ReplyToken, ctx.GroupID, poll.Question, []flex.Card{makePollCard(ctx.Lang, poll, nil)})
+}
+
+func listPolls(ctx *commandContext) {
+	polls, err := pollRepo.ListOpen(ctx.GroupID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(polls) == 0 {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.none"))
+		return
+	}
+	cards := make([]flex.Card, 0, len(polls))
+	for _, poll := range polls {
+		votes, err := pollRepo.ListVotes(poll.ID)
+		if err != nil {
+			log.Println(err)
+		}
+		cards = append(cards, makePollCard(ctx.Lang, poll, votes))
+	}
+	replyCarousel(ctx.Event.ReplyToken, ctx.GroupID, i18n.T(ctx.Lang, "poll.open"), cards)
+}
+
+// findPoll looks up a poll by number, only within the group it belongs to.
+func findPoll(replyToken, lang, groupID, idText string) (repositories.Poll, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(idText, "#"))
+	if err != nil {
+		replyText(replyToken, i18n.T(lang, "poll.usage"))
+		return repositories.Poll{}, false
+	}
+	poll, err := pollRepo.Get(id)
+	if err != nil || poll.GroupID != groupID {
+		if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			log.Println(err)
+		}
+		replyText(replyToken, i18n.T(lang, "poll.not_found", idText))
+		return repositories.Poll{}, false
+	}
+	return poll, true
+}
+
+func countVotes(votes []repositories.PollVote) map[int]int {
+	counts := map[int]int{}
+	for _, vote := range votes {
+		counts[vote.Position]++
+	}
+	return counts
+}
+
+// makePollCard shows the question with one button per option carrying its
+// current vote count.
+func makePollCard(lang string, poll repositories.Poll, votes []repositories.PollVote) flex.Card {
+	counts := countVotes(votes)
+	body := []linebot.FlexComponent{
+		flex.Title(poll.Question),
+		flex.Text(i18n.T(lang, "poll.hint", poll.ID)),
+	}
+	if poll.ClosesAt != nil {
+		body = append(body, flex.Row(i18n.T(lang, "poll.deadline"), formatClubTime(*poll.ClosesAt)))
+	}
+
+	buttons := make([]linebot.FlexComponent, 0, len(poll.Options)+1)
+	for _, option := range poll.Options {
+		button := flex.Button(linebot.NewPostbackAction(
+			fmt.Sprintf("%s (%d)", option.Text, counts[option.Position]),
+			fmt.Sprintf("action=poll&id=%d&o=%d", poll.ID, option.Position), "", ""))
+		button.Style = linebot.FlexButtonStyleTypeSecondary
+		button.Color = ""
+		buttons = append(buttons, button)
+	}
+	buttons = append(buttons, flex.Button(linebot.NewPostbackAction(
+		i18n.T(lang, "poll.result"), fmt.Sprintf("action=poll&id=%d&op=result", poll.ID), "", "")))
+	footer := flex.Box(buttons...)
+	footer.Spacing = linebot.FlexComponentSpacingTypeSm
+
+	options := make([]string, 0, len(poll.Options))
+	for _, option := range poll.Options {
+		options = append(options, option.Text)
+	}
+	return flex.Card{
+		Bubble: flex.Bubble(nil, flex.Box(body...), footer),
+		Alt:    fmt.Sprintf("%s (%s)", poll.Question, strings.Join(options, " / ")),
+	}
+}
+
+// pollTally renders the results under the title key. Votes are public, so
+// each option lists who picked it.
+func pollTally(lang string, poll repositories.Poll, votes []repositories.PollVote, title string) string {
+	voters := map[int][]string{}
+	for _, vote := range votes {
+		voters[vote.Position] = append(voters[vote.Position], vote.UserName)
+	}
+	lines := []string{i18n.T(lang, title, poll.Question, len(votes))}
+	for _, option := range poll.Options {
+		names := voters[option.Position]
+		percent := 0
+		if len(votes) > 0 {
+			percent = len(names) * 100 / len(votes)
+		}
+		line := i18n.T(lang, "poll.tally_line", option.Text, len(names), percent)
+		if len(names) > 0 {
+			line += " " + strings.Join(names, "、")
+		}
+		lines = append(lines, line)
+	}
+	if poll.ClosesAt != nil && poll.Open() {
+		lines = append(lines, fmt.Sprintf("%s: %s", i18n.T(lang, "poll.deadline"), formatClubTime(*poll.ClosesAt)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func replyPollResult(replyToken, lang string, poll repositories.Poll) {
+	votes, err := pollRepo.ListVotes(poll.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	title := "poll.tally"
+	if !poll.Open() {
+		title = "poll.final"
+	}
+	replyText(replyToken, pollTally(lang, poll, votes, title))
+}
+
+// closePoll is open to the poll's creator and to admins.
+func closePoll(ctx *commandContext, poll repositories.Poll) {
+	if poll.CreatorID != ctx.UserID && !hasRole(ctx.UserID, ctx.GroupID, repositories.RoleAdmin) {
+		replyText(ctx.Event.ReplyToken, i18n.T(ctx.Lang, "poll.close_denied"))
+		return
+	}
+	if _, err := pollRepo.Close(poll.ID); err != nil {
+		log.Println(err)
+		return
+	}
+	now := time.Now()
+	poll.ClosedAt = &now
+	replyPollResult(ctx.Event.ReplyToken, ctx.Lang, poll)
+}
+
+// handlePollPostback records a vote, or shows the tally for op=result.
+func handlePollPostback(event *linebot.Event, values url.Values) {
+	groupID := event.Source.GroupID
+	lang := languageOf(groupID)
+	poll, ok := findPoll(event.ReplyToken, lang, groupID, values.Get("id"))
+	if !ok {
+		return
+	}
+	if values.Get("op") == "result" {
+		replyPollResult(event.ReplyToken, lang, poll)
+		return
+	}
+	position, err := strconv.Atoi(values.Get("o"))
+	if err != nil || position < 0 || position >= len(poll.Options) {
+		log.Printf("invalid poll postback: %v", values)
+		return
+	}
+	castVote(event.ReplyToken, lang, event.Source.UserID, poll, position)
+}
+
+// castVote records the user's choice; voting again replaces it.
+func castVote(replyToken, lang, userID string, poll repositories.Poll, position int) {
+	if !poll.Open() {
+		replyText(replyToken, i18n.T(lang, "poll.closed", poll.Question))
+		return
+	}
+	name := displayName(poll.GroupID, userID)
+	if err := pollRepo.Vote(repositories.PollVote{
+		PollID:   poll.ID,
+		UserID:   userID,
+		UserName: name,
+		Position: position,
+	}); err != nil {
+		log.Println(err)
+		return
+	}
+
+	votes, err := pollRepo.ListVotes(poll.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	option := poll.Options[position]
+	replyText(replyToken, i18n.T(lang, "poll.voted", name, option.Text, countVotes(votes)[position]))
+}
+
+// startPollCloser closes polls whose close time has passed and posts the
+// final results to their group.
+func startPollCloser() {
+	go func() {
+		for {
+			closeDuePolls()
+			time.Sleep(pollCloseInterval)
+		}
+	}()
+}
+
+func closeDuePolls() {
+	polls, err := pollRepo.ListDueToClose()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, poll := range polls {
+		closed, err := pollRepo.Close(poll.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !closed {
+			continue
+		}
+		votes, err := pollRepo.ListVotes(poll.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		lang := languageOf(poll.GroupID)
+		if _, err := bot.PushMessage(poll.GroupID, linebot.NewTextMessage(pollTally(lang, poll, votes, "poll.final"))).Do(); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"net/url"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// Postback data is a URL query string whose "action" picks the handler,
+// e.g. "action=quiz&q=1&a=0".
+func handlePostback(event *linebot.Event) {
+	values, err := url.ParseQuery(event.Postback.Data)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	switch values.Get("action") {
+	case "quiz":
+		handleQuizPostback(event, values)
+	case "verify":
+		handleVerifyPostback(event, values)
+	case "flow":
+		flows.HandlePostback(event.ReplyToken, event.Source.UserID, values)
+	case "menu":
+		handleMenuPostback(event, values)
+	case "search":
+		handleSearchPostback(event, values)
+	case "event":
+		handleEventPostback(event, values)
+	case "poll":
+		handlePollPostback(event, values)
+	case "buy":
+		handleGroupBuyPostback(event, values)
+	default:
+		log.Printf("unknown postback: %s", event.Postback.Data)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/cache"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const defaultProfileCacheTTL = time.Hour
+
+var (
+	profileCache    cache.Backend
+	profileCacheTTL = defaultProfileCacheTTL
+)
+
+var errNotMember = errors.New("not a member of the group")
+
+type cachedProfile struct {
+	Member  bool                         `json:"member"`
+	Profile *linebot.UserProfileResponse `json:"profile,omitempty"`
+}
+
+func initProfileCache() {
+	if os.Getenv("CACHE_BACKEND") == "postgres" {
+		profileCache = repositories.NewCacheRepository()
+	} else {
+		profileCache = cache.NewMemory()
+	}
+	if v, err := time.ParseDuration(os.Getenv("CACHE_TTL")); err == nil && v > 0 {
+		profileCacheTTL = v
+	}
+}
+
+func memberCacheKey(groupID, userID string) string {
+	return fmt.Sprintf("member:%s:%s", groupID, userID)
+}
+
+// groupMemberProfile is a cached bot.GetGroupMemberProfile. Non-membership is
+// cached too and reported as errNotMember, which is what makes the
+// per-regional-group authorization loops cheap.
+func groupMemberProfile(groupID, userID string) (*linebot.UserProfileResponse, error) {
+	if data, ok, err := profileCache.Get(memberCacheKey(groupID, userID)); err != nil {
+		log.Println(err)
+	} else if ok {
+		var cached cachedProfile
+		if err := json.Unmarshal(data, &cached); err == nil {
+			if !cached.Member {
+				return nil, errNotMember
+			}
+			return cached.Profile, nil
+		}
+	}
+	return fetchGroupMemberProfile(groupID, userID)
+}
+
+// fetchGroupMemberProfile always asks LINE and refreshes the cache with the
+// answer. Transient API errors are returned without being cached.
+func fetchGroupMemberProfile(groupID, userID string) (*linebot.UserProfileResponse, error) {
+	profile, err := bot.GetGroupMemberProfile(groupID, userID).Do()
+	cached := cachedProfile{Member: true, Profile: profile}
+	if err != nil {
+		if !isNotMember(err) {
+			return nil, err
+		}
+		cached = cachedProfile{}
+		err = errNotMember
+	}
+
+	if data, mErr := json.Marshal(cached); mErr != nil {
+		log.Println(mErr)
+	} else if sErr := profileCache.Set(memberCacheKey(groupID, userID), data, profileCacheTTL); sErr != nil {
+		log.Println(sErr)
+	}
+	return profile, err
+}
+
+func invalidateGroupMember(groupID, userID string) {
+	if err := profileCache.Delete(memberCacheKey(groupID, userID)); err != nil {
+		log.Println(err)
+	}
+}
+
+func isNotMember(err error) bool {
+	if errors.Is(err, errNotMember) {
+		return true
+	}
+	var apiErr *linebot.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const defaultReconcileRate = 5
+
+// startMembershipReconciler re-checks every catcher against the regional
+// groups every RECONCILE_INTERVAL, so rows follow people who joined or left
+// groups while the bot was not watching, and rows in groups that are no
+// longer regional are dropped. It is off unless RECONCILE_INTERVAL is set,
+// and the first pass waits one interval so restarts do not trigger it.
+// Membership goes through the profile cache, and lookups are throttled to
+// RECONCILE_RATE per second.
+func startMembershipReconciler() {
+	interval, err := time.ParseDuration(os.Getenv("RECONCILE_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return
+	}
+	rate := defaultReconcileRate
+	if v, err := strconv.Atoi(os.Getenv("RECONCILE_RATE")); err == nil && v > 0 {
+		rate = v
+	}
+
+	go func() {
+		limiter := time.NewTicker(time.Second / time.Duration(rate))
+		defer limiter.Stop()
+		for {
+			time.Sleep(interval)
+			reconcileMemberships(limiter.C)
+		}
+	}()
+}
+
+func reconcileMemberships(limiter <-chan time.Time) {
+	all, err := catcherRepo.ListAll()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	// A failed lookup must not look like "no regional groups", or every row
+	// would be dropped below.
+	regional, err := groupRepo.ListByType(repositories.GroupTypeRegional)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	groups := make(map[string]string, len(regional))
+	for _, group := range regional {
+		groups[group.GroupID] = group.Name
+	}
+
+	byUser := map[string][]repositories.Catcher{}
+	userIDs := make([]string, 0)
+	for _, catcher := range all {
+		if _, ok := byUser[catcher.UserID]; !ok {
+			userIDs = append(userIDs, catcher.UserID)
+		}
+		byUser[catcher.UserID] = append(byUser[catcher.UserID], catcher)
+	}
+
+	for _, userID := range userIDs {
+		rows := byUser[userID]
+		// New rows copy the user's most recently edited one.
+		latest := rows[0]
+		existing := map[string]repositories.Catcher{}
+		for _, row := range rows {
+			existing[row.GroupID] = row
+			if row.UpdatedAt.After(latest.UpdatedAt) {
+				latest = row
+			}
+			// Rows in groups that were reclassified as non-regional, or
+			// that the bot has left, are no longer checked below.
+			if _, ok := groups[row.GroupID]; !ok {
+				log.Printf("reconcile: dropping user %s from non-regional group %s", userID, row.GroupID)
+				if err := catcherRepo.DeleteByGroupAndUser(row.GroupID, userID); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+
+		for groupID, groupName := range groups {
+			<-limiter
+			profile, err := groupMemberProfile(groupID, userID)
+			row, found := existing[groupID]
+			if err != nil {
+				if isNotMember(err) && found {
+					log.Printf("reconcile: user %s left group %s", userID, groupID)
+					if err := catcherRepo.DeleteByGroupAndUser(groupID, userID); err != nil {
+						log.Println(err)
+					}
+					if err := memberRepo.Leave(groupID, userID); err != nil {
+						log.Println(err)
+					}
+				} else if !isNotMember(err) {
+					log.Println(err)
+				}
+				continue
+			}
+
+			// Existing rows are only rewritten when something changed, so
+			// updated_at keeps reflecting the user's own edits.
+			if found && row.UserName == profile.DisplayName && row.GroupName == groupName {
+				continue
+			}
+			if !found {
+				row = latest
+				row.ID = 0
+				row.GroupID = groupID
+			}
+			row.UserName = profile.DisplayName
+			row.GroupName = groupName
+			row.UpdatedAt = time.Time{}
+			if _, err := catcherRepo.Create(row); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CacheEntry struct {
+	Key       string `gorm:"primaryKey"`
+	Value     []byte
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+type CacheRepository interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+type cacheRepository struct {
+	db *gorm.DB
+}
+
+func NewCacheRepository() CacheRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&CacheEntry{}); err != nil {
+		panic(err)
+	}
+	return &cacheRepository{db: db}
+}
+
+func (r *cacheRepository) Get(key string) ([]byte, bool, error) {
+	var entry CacheEntry
+	err := r.db.Where("key = ? AND expires_at > ?", key, time.Now()).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return entry.Value, true, nil
+}
+
+func (r *cacheRepository) Set(key string, value []byte, ttl time.Duration) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "expires_at"}),
+	}).Create(&CacheEntry{Key: key, Value: value, ExpiresAt: time.Now().Add(ttl)}).Error
+}
+
+// Delete also purges expired rows, which Get only filters out.
+func (r *cacheRepository) Delete(key string) error {
+	return r.db.Where("key = ? OR expires_at <= ?", key, time.Now()).Delete(&CacheEntry{}).Error
+}
@@ -1,9 +1,8 @@
 package repositories
 
 import (
-	"os"
+	"time"
 
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -11,18 +10,32 @@ import (
 type Catcher struct {
 	ID                 int
 	LicensePlateNumber string
-	UserID             string
+	UserID             string `gorm:"uniqueIndex:idx_catchers_group_user"`
 	UserName           string
 	SelfIntro          string
 	HauntedPlaces      string
+	City               string `gorm:"index:idx_catchers_city_district"`
+	District           string `gorm:"index:idx_catchers_city_district"`
+	Latitude           *float64
+	Longitude          *float64
 	CoverURL           string
-	GroupID            string
+	GroupID            string `gorm:"uniqueIndex:idx_catchers_group_user"`
 	GroupName          string
+	UpdatedAt          time.Time
+}
+
+// CatcherPreference holds per-user settings that apply to all of the
+// user's catcher rows, whichever groups they are in.
+type CatcherPreference struct {
+	ID           int
+	UserID       string `gorm:"uniqueIndex"`
+	Discoverable bool
+	UpdatedAt    time.Time
 }
 
 type WildCatcher struct {
 	ID                 int
-	LicensePlateNumber string
+	LicensePlateNumber string `gorm:"uniqueIndex"`
 	Count              int
 }
 
@@ -30,6 +43,13 @@ type CatchersRepository interface {
 	Create(catcher Catcher) (int, error)
 	SearchByLicensePlateNumber(groupID, licensePlateNumber string) ([]Catcher, error)
 	IncreaseWildCatcher(licensePlateNumber string) (int, error)
+	DeleteByGroupAndUser(groupID, userID string) error
+	ListAll() ([]Catcher, error)
+	ListByUser(userID string) ([]Catcher, error)
+	TopWildCatchers(limit int) ([]WildCatcher, error)
+	ListByArea(city, district string) ([]Catcher, error)
+	ListDiscoverable() ([]Catcher, error)
+	SetDiscoverable(userID string, discoverable bool) (bool, error)
 }
 
 type catcherRepository struct {
@@ -37,8 +57,8 @@ type catcherRepository struct {
 }
 
 func NewCatcherRepository() CatchersRepository {
-	db, err := gorm.Open(postgres.Open(os.Getenv("DATABASE_URL")), &gorm.Config{})
-	if err != nil {
+	db := openDB()
+	if err := db.AutoMigrate(&Catcher{}, &WildCatcher{}, &CatcherPreference{}); err != nil {
 		panic(err)
 	}
 	return &catcherRepository{db: db}
@@ -47,14 +67,22 @@ func NewCatcherRepository() CatchersRepository {
 func (r *catcherRepository) Create(catcher Catcher) (int, error) {
 	return catcher.ID, r.db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "group_id"}, {Name: "user_id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"license_plate_number", "user_name", "haunted_places", "self_intro", "cover_url", "group_name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"license_plate_number", "user_name", "haunted_places", "city", "district", "latitude", "longitude", "self_intro", "cover_url", "group_name", "updated_at"}),
 	}).Create(&catcher).Error
 }
 
+// SearchByLicensePlateNumber matches plates containing the digits. Plates
+// whose whole number part equals them ("ABC-1234", "1234-AB") come first,
+// then the most recently updated.
 func (r *catcherRepository) SearchByLicensePlateNumber(groupID, licensePlateNumber string) ([]Catcher, error) {
 	var result []Catcher
 	return result, r.db.
 		Where("license_plate_number like ?", "%"+licensePlateNumber+"%").
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "CASE WHEN license_plate_number LIKE ? OR license_plate_number LIKE ? THEN 0 ELSE 1 END, updated_at DESC NULLS LAST, id",
+			Vars:               []interface{}{"%-" + licensePlateNumber, licensePlateNumber + "-%"},
+			WithoutParentheses: true,
+		}}).
 		Find(&result).Error
 }
 
@@ -73,3 +101,64 @@ func (r *catcherRepository) IncreaseWildCatcher(licensePlateNumber string) (int,
 
 	return wildCatcher.Count, r.db.Where("license_plate_number = ?", licensePlateNumber).First(&wildCatcher).Error
 }
+
+func (r *catcherRepository) DeleteByGroupAndUser(groupID, userID string) error {
+	return r.db.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&Catcher{}).Error
+}
+
+func (r *catcherRepository) ListAll() ([]Catcher, error) {
+	var result []Catcher
+	return result, r.db.Order("user_id, id").Find(&result).Error
+}
+
+func (r *catcherRepository) ListByUser(userID string) ([]Catcher, error) {
+	var result []Catcher
+	return result, r.db.Where("user_id = ?", userID).Order("id").Find(&result).Error
+}
+
+func (r *catcherRepository) TopWildCatchers(limit int) ([]WildCatcher, error) {
+	var result []WildCatcher
+	return result, r.db.Order("count desc, license_plate_number").Limit(limit).Find(&result).Error
+}
+
+// discoverableUsers limits a query to users who opted in to being found.
+func discoverableUsers(db *gorm.DB) *gorm.DB {
+	return db.Where("user_id IN (?)", db.Session(&gorm.Session{NewDB: true}).
+		Model(&CatcherPreference{}).Select("user_id").Where("discoverable"))
+}
+
+// ListByArea matches whichever of city and district are non-empty.
+func (r *catcherRepository) ListByArea(city, district string) ([]Catcher, error) {
+	var result []Catcher
+	if city == "" && district == "" {
+		return result, nil
+	}
+	query := r.db
+	if city != "" {
+		query = query.Where("city = ?", city)
+	}
+	if district != "" {
+		query = query.Where("district = ?", district)
+	}
+	return result, query.Order("user_id, id").Find(&result).Error
+}
+
+// ListDiscoverable returns the rows of users who opted in to being found
+// from a shared location.
+func (r *catcherRepository) ListDiscoverable() ([]Catcher, error) {
+	var result []Catcher
+	return result, r.db.Scopes(discoverableUsers).Where("city <> ''").Order("user_id, id").Find(&result).Error
+}
+
+// SetDiscoverable stores the user's opt-in and reports whether the user
+// has registered as a catcher at all.
+func (r *catcherRepository) SetDiscoverable(userID string, discoverable bool) (bool, error) {
+	var count int64
+	if err := r.db.Model(&Catcher{}).Where("user_id = ?", userID).Count(&count).Error; err != nil || count == 0 {
+		return false, err
+	}
+	return true, r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"discoverable", "updated_at"}),
+	}).Create(&CatcherPreference{UserID: userID, Discoverable: discoverable}).Error
+}
@@ -0,0 +1,27 @@
+package repositories
+
+import (
+	"os"
+	"sync"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var ErrNotFound = gorm.ErrRecordNotFound
+
+var (
+	dbOnce sync.Once
+	db     *gorm.DB
+)
+
+func openDB() *gorm.DB {
+	dbOnce.Do(func() {
+		var err error
+		db, err = gorm.Open(postgres.Open(os.Getenv("DATABASE_URL")), &gorm.Config{})
+		if err != nil {
+			panic(err)
+		}
+	})
+	return db
+}
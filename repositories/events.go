@@ -0,0 +1,142 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrEventFull = errors.New("event is full")
+
+type Event struct {
+	ID         int
+	GroupID    string `gorm:"index"`
+	Title      string
+	StartsAt   time.Time
+	Place      string
+	Capacity   int
+	CreatorID  string
+	RemindedAt *time.Time
+	CreatedAt  time.Time
+}
+
+type EventRSVP struct {
+	ID        int
+	EventID   int    `gorm:"uniqueIndex:idx_event_rsvps_event_user"`
+	UserID    string `gorm:"uniqueIndex:idx_event_rsvps_event_user"`
+	UserName  string
+	CreatedAt time.Time
+}
+
+type EventsRepository interface {
+	Create(event Event) (int, error)
+	Get(id int) (Event, error)
+	Delete(id int) error
+	ListUpcoming(groupID string) ([]Event, error)
+	Join(rsvp EventRSVP) error
+	Leave(eventID int, userID string) (bool, error)
+	ListAttendees(eventID int) ([]EventRSVP, error)
+	CountAttendees(eventID int) (int, error)
+	ListDueReminders(until time.Time) ([]Event, error)
+	MarkReminded(id int) error
+}
+
+type eventRepository struct {
+	db *gorm.DB
+}
+
+func NewEventRepository() EventsRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&Event{}, &EventRSVP{}); err != nil {
+		panic(err)
+	}
+	return &eventRepository{db: db}
+}
+
+func (r *eventRepository) Create(event Event) (int, error) {
+	err := r.db.Create(&event).Error
+	return event.ID, err
+}
+
+func (r *eventRepository) Get(id int) (Event, error) {
+	var event Event
+	return event, r.db.First(&event, id).Error
+}
+
+func (r *eventRepository) Delete(id int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("event_id = ?", id).Delete(&EventRSVP{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Event{}, id).Error
+	})
+}
+
+func (r *eventRepository) ListUpcoming(groupID string) ([]Event, error) {
+	var result []Event
+	return result, r.db.Where("group_id = ? AND starts_at > ?", groupID, time.Now()).Order("starts_at, id").Find(&result).Error
+}
+
+// Join records an RSVP. Joining twice is a no-op, and ErrEventFull is
+// returned once the capacity is reached; a capacity of 0 means unlimited.
+// The event row is locked so concurrent joins cannot overfill it.
+func (r *eventRepository) Join(rsvp EventRSVP) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var event Event
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&event, rsvp.EventID).Error; err != nil {
+			return err
+		}
+
+		var joined int64
+		if err := tx.Model(&EventRSVP{}).Where("event_id = ? AND user_id = ?", rsvp.EventID, rsvp.UserID).Count(&joined).Error; err != nil {
+			return err
+		}
+		if joined > 0 {
+			return nil
+		}
+
+		if event.Capacity > 0 {
+			var count int64
+			if err := tx.Model(&EventRSVP{}).Where("event_id = ?", rsvp.EventID).Count(&count).Error; err != nil {
+				return err
+			}
+			if int(count) >= event.Capacity {
+				return ErrEventFull
+			}
+		}
+		return tx.Create(&rsvp).Error
+	})
+}
+
+// Leave removes an RSVP and reports whether there was one.
+func (r *eventRepository) Leave(eventID int, userID string) (bool, error) {
+	result := r.db.Where("event_id = ? AND user_id = ?", eventID, userID).Delete(&EventRSVP{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *eventRepository) ListAttendees(eventID int) ([]EventRSVP, error) {
+	var result []EventRSVP
+	return result, r.db.Where("event_id = ?", eventID).Order("created_at, id").Find(&result).Error
+}
+
+func (r *eventRepository) CountAttendees(eventID int) (int, error) {
+	var count int64
+	err := r.db.Model(&EventRSVP{}).Where("event_id = ?", eventID).Count(&count).Error
+	return int(count), err
+}
+
+// ListDueReminders returns events starting between now and until that have
+// not been reminded yet.
+func (r *eventRepository) ListDueReminders(until time.Time) ([]Event, error) {
+	var result []Event
+	return result, r.db.
+		Where("reminded_at IS NULL AND starts_at > ? AND starts_at <= ?", time.Now(), until).
+		Order("starts_at").
+		Find(&result).Error
+}
+
+func (r *eventRepository) MarkReminded(id int) error {
+	return r.db.Model(&Event{}).Where("id = ?", id).Update("reminded_at", time.Now()).Error
+}
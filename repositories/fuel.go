@@ -0,0 +1,103 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FuelLog struct {
+	ID                 int
+	UserID             string `gorm:"index:idx_fuel_logs_user_plate"`
+	LicensePlateNumber string `gorm:"index:idx_fuel_logs_user_plate"`
+	Odometer           int
+	Litres             float64
+	Amount             int
+	FilledAt           time.Time
+}
+
+// FuelVehicle holds per-car details the fuel statistics are grouped by.
+type FuelVehicle struct {
+	ID                 int
+	UserID             string `gorm:"uniqueIndex:idx_fuel_vehicles_user_plate"`
+	LicensePlateNumber string `gorm:"uniqueIndex:idx_fuel_vehicles_user_plate"`
+	ModelYear          int
+	UpdatedAt          time.Time
+}
+
+// CarEconomy is one car's overall economy, without saying whose car it is.
+type CarEconomy struct {
+	ModelYear int
+	Economy   float64
+}
+
+type FuelRepository interface {
+	Create(log FuelLog) (int, error)
+	ListByPlate(userID, plate string) ([]FuelLog, error)
+	Delete(userID string, id int) (bool, error)
+	SetModelYear(vehicle FuelVehicle) error
+	GetVehicle(userID, plate string) (FuelVehicle, error)
+	ListCarEconomies(minEconomy, maxEconomy float64) ([]CarEconomy, error)
+}
+
+type fuelRepository struct {
+	db *gorm.DB
+}
+
+func NewFuelRepository() FuelRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&FuelLog{}, &FuelVehicle{}); err != nil {
+		panic(err)
+	}
+	return &fuelRepository{db: db}
+}
+
+func (r *fuelRepository) Create(log FuelLog) (int, error) {
+	err := r.db.Create(&log).Error
+	return log.ID, err
+}
+
+// ListByPlate returns one car's fill-ups in odometer order.
+func (r *fuelRepository) ListByPlate(userID, plate string) ([]FuelLog, error) {
+	var result []FuelLog
+	return result, r.db.Where("user_id = ? AND license_plate_number = ?", userID, plate).
+		Order("odometer, id").
+		Find(&result).Error
+}
+
+// Delete removes one of the user's fill-ups and reports whether it existed.
+func (r *fuelRepository) Delete(userID string, id int) (bool, error) {
+	result := r.db.Where("user_id = ? AND id = ?", userID, id).Delete(&FuelLog{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *fuelRepository) SetModelYear(vehicle FuelVehicle) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "license_plate_number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"model_year", "updated_at"}),
+	}).Create(&vehicle).Error
+}
+
+func (r *fuelRepository) GetVehicle(userID, plate string) (FuelVehicle, error) {
+	var vehicle FuelVehicle
+	return vehicle, r.db.Where("user_id = ? AND license_plate_number = ?", userID, plate).First(&vehicle).Error
+}
+
+// ListCarEconomies returns each car's total distance over total litres,
+// counting only fill-ups whose economy since the previous fill-up lies
+// between minEconomy and maxEconomy, as the per-car history does.
+func (r *fuelRepository) ListCarEconomies(minEconomy, maxEconomy float64) ([]CarEconomy, error) {
+	var result []CarEconomy
+	return result, r.db.Raw(`SELECT COALESCE(v.model_year, 0) AS model_year,
+			(SUM(f.distance) / SUM(f.litres))::float8 AS economy
+		FROM (
+			SELECT user_id, license_plate_number, litres,
+				odometer - LAG(odometer) OVER (PARTITION BY user_id, license_plate_number ORDER BY odometer, id) AS distance
+			FROM fuel_logs
+		) f
+		LEFT JOIN fuel_vehicles v ON v.user_id = f.user_id AND v.license_plate_number = f.license_plate_number
+		WHERE f.litres > 0 AND f.distance > 0 AND f.distance / f.litres BETWEEN ? AND ?
+		GROUP BY f.user_id, f.license_plate_number, v.model_year`, minEconomy, maxEconomy).
+		Scan(&result).Error
+}
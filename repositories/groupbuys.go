@@ -0,0 +1,169 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GroupBuy struct {
+	ID          int
+	GroupID     string `gorm:"index"`
+	Title       string
+	OrganizerID string
+	Deadline    time.Time
+	ClosedAt    *time.Time
+	Items       []GroupBuyItem
+	CreatedAt   time.Time
+}
+
+type GroupBuyItem struct {
+	ID         int
+	GroupBuyID int `gorm:"index"`
+	Position   int
+	Name       string
+	Variant    string
+	Price      int
+}
+
+type GroupBuyOrder struct {
+	ID         int
+	GroupBuyID int    `gorm:"index"`
+	ItemID     int    `gorm:"uniqueIndex:idx_group_buy_orders_item_user"`
+	UserID     string `gorm:"uniqueIndex:idx_group_buy_orders_item_user"`
+	UserName   string
+	Quantity   int
+	Paid       bool
+	UpdatedAt  time.Time
+}
+
+// Open reports whether the group-buy still takes orders.
+func (b GroupBuy) Open() bool {
+	return b.ClosedAt == nil && b.Deadline.After(time.Now())
+}
+
+type GroupBuysRepository interface {
+	Create(buy GroupBuy) (int, error)
+	Get(id int) (GroupBuy, error)
+	ListOpen(groupID string) ([]GroupBuy, error)
+	AddQuantity(order GroupBuyOrder, limit int) (int, bool, error)
+	SetQuantity(order GroupBuyOrder) error
+	DeleteOrders(buyID int, userID string) (int, error)
+	ListOrders(buyID int) ([]GroupBuyOrder, error)
+	SetPaid(buyID int, userID string, paid bool) (bool, error)
+	Close(id int) (bool, error)
+	ListDueToClose() ([]GroupBuy, error)
+}
+
+type groupBuyRepository struct {
+	db *gorm.DB
+}
+
+func NewGroupBuyRepository() GroupBuysRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&GroupBuy{}, &GroupBuyItem{}, &GroupBuyOrder{}); err != nil {
+		panic(err)
+	}
+	return &groupBuyRepository{db: db}
+}
+
+func orderedItems(db *gorm.DB) *gorm.DB {
+	return db.Order("position")
+}
+
+// Create saves the group-buy together with its items.
+func (r *groupBuyRepository) Create(buy GroupBuy) (int, error) {
+	err := r.db.Create(&buy).Error
+	return buy.ID, err
+}
+
+func (r *groupBuyRepository) Get(id int) (GroupBuy, error) {
+	var buy GroupBuy
+	return buy, r.db.Preload("Items", orderedItems).First(&buy, id).Error
+}
+
+func (r *groupBuyRepository) ListOpen(groupID string) ([]GroupBuy, error) {
+	var result []GroupBuy
+	return result, r.db.Preload("Items", orderedItems).
+		Where("group_id = ? AND closed_at IS NULL AND deadline > ?", groupID, time.Now()).
+		Order("deadline, id").
+		Find(&result).Error
+}
+
+// AddQuantity adds order.Quantity to the user's order of the item unless
+// that would exceed limit, and returns the resulting quantity and whether
+// it was added. A paid order goes back to unpaid, since more is now owed.
+func (r *groupBuyRepository) AddQuantity(order GroupBuyOrder, limit int) (int, bool, error) {
+	result := r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "item_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   gorm.Expr("group_buy_orders.quantity + ?", order.Quantity),
+			"user_name":  order.UserName,
+			"paid":       false,
+			"updated_at": time.Now(),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			gorm.Expr("group_buy_orders.quantity + ? <= ?", order.Quantity, limit),
+		}},
+	}).Create(&order)
+	if result.Error != nil {
+		return 0, false, result.Error
+	}
+
+	var saved GroupBuyOrder
+	err := r.db.Where("item_id = ? AND user_id = ?", order.ItemID, order.UserID).First(&saved).Error
+	return saved.Quantity, result.RowsAffected > 0, err
+}
+
+// SetQuantity replaces the user's order of the item; a quantity of 0
+// removes it. Raising the quantity of a paid order marks it unpaid again.
+func (r *groupBuyRepository) SetQuantity(order GroupBuyOrder) error {
+	if order.Quantity <= 0 {
+		return r.db.Where("item_id = ? AND user_id = ?", order.ItemID, order.UserID).Delete(&GroupBuyOrder{}).Error
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "item_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   order.Quantity,
+			"user_name":  order.UserName,
+			"paid":       gorm.Expr("group_buy_orders.paid AND group_buy_orders.quantity >= ?", order.Quantity),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&order).Error
+}
+
+func (r *groupBuyRepository) DeleteOrders(buyID int, userID string) (int, error) {
+	result := r.db.Where("group_buy_id = ? AND user_id = ?", buyID, userID).Delete(&GroupBuyOrder{})
+	return int(result.RowsAffected), result.Error
+}
+
+func (r *groupBuyRepository) ListOrders(buyID int) ([]GroupBuyOrder, error) {
+	var result []GroupBuyOrder
+	return result, r.db.Where("group_buy_id = ?", buyID).Order("user_name, user_id, item_id").Find(&result).Error
+}
+
+// SetPaid marks all of the user's orders in the group-buy and reports
+// whether there were any.
+func (r *groupBuyRepository) SetPaid(buyID int, userID string, paid bool) (bool, error) {
+	result := r.db.Model(&GroupBuyOrder{}).
+		Where("group_buy_id = ? AND user_id = ?", buyID, userID).
+		Update("paid", paid)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Close marks the group-buy closed and reports whether it was still open,
+// so the summary is announced only once.
+func (r *groupBuyRepository) Close(id int) (bool, error) {
+	result := r.db.Model(&GroupBuy{}).Where("id = ? AND closed_at IS NULL", id).Update("closed_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListDueToClose returns group-buys past their deadline that have not been
+// closed yet.
+func (r *groupBuyRepository) ListDueToClose() ([]GroupBuy, error) {
+	var result []GroupBuy
+	return result, r.db.Preload("Items", orderedItems).
+		Where("closed_at IS NULL AND deadline <= ?", time.Now()).
+		Find(&result).Error
+}
@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GroupType string
+
+const (
+	GroupTypeRegional GroupType = "regional"
+	GroupTypeGeneral  GroupType = "general"
+	GroupTypeTest     GroupType = "test"
+	GroupTypeAdmin    GroupType = "admin"
+)
+
+type Group struct {
+	ID        int
+	GroupID   string `gorm:"uniqueIndex"`
+	Name      string
+	Type      GroupType
+	Region    string
+	Language  string
+	TextOnly  bool
+	LeftAt    *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type GroupsRepository interface {
+	Register(group Group) error
+	Get(groupID string) (Group, error)
+	List() ([]Group, error)
+	ListByType(groupType GroupType) ([]Group, error)
+	Update(group Group) error
+	SetLeft(groupID string, left bool) error
+}
+
+type groupRepository struct {
+	db *gorm.DB
+}
+
+func NewGroupRepository() GroupsRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&Group{}); err != nil {
+		panic(err)
+	}
+	return &groupRepository{db: db}
+}
+
+// Register inserts the group unless it is already known, so an admin's
+// classification is never overwritten by a later join event.
+func (r *groupRepository) Register(group Group) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "group_id"}},
+		DoNothing: true,
+	}).Create(&group).Error
+}
+
+func (r *groupRepository) Get(groupID string) (Group, error) {
+	var group Group
+	return group, r.db.Where("group_id = ?", groupID).First(&group).Error
+}
+
+func (r *groupRepository) List() ([]Group, error) {
+	var result []Group
+	return result, r.db.Order("type, name").Find(&result).Error
+}
+
+func (r *groupRepository) ListByType(groupType GroupType) ([]Group, error) {
+	var result []Group
+	return result, r.db.Where("type = ? AND left_at IS NULL", groupType).Order("name").Find(&result).Error
+}
+
+func (r *groupRepository) Update(group Group) error {
+	return r.db.Model(&Group{}).
+		Where("group_id = ?", group.GroupID).
+		Updates(map[string]interface{}{"name": group.Name, "type": group.Type, "region": group.Region, "language": group.Language, "text_only": group.TextOnly}).Error
+}
+
+func (r *groupRepository) SetLeft(groupID string, left bool) error {
+	var leftAt *time.Time
+	if left {
+		now := time.Now()
+		leftAt = &now
+	}
+	return r.db.Model(&Group{}).Where("group_id = ?", groupID).Update("left_at", leftAt).Error
+}
@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type MaintenanceRecord struct {
+	ID                 int
+	UserID             string `gorm:"index:idx_maintenance_records_user_plate"`
+	LicensePlateNumber string `gorm:"index:idx_maintenance_records_user_plate"`
+	ServicedAt         time.Time
+	Mileage            int
+	Items              string
+	Cost               int
+	Shop               string
+	RemindedAt         *time.Time
+	CreatedAt          time.Time
+}
+
+type MaintenanceRepository interface {
+	Create(record MaintenanceRecord) (int, error)
+	ListByUser(userID string) ([]MaintenanceRecord, error)
+	Delete(userID string, id int) (bool, error)
+	ListLatestUnreminded() ([]MaintenanceRecord, error)
+	MarkReminded(id int) error
+}
+
+type maintenanceRepository struct {
+	db *gorm.DB
+}
+
+func NewMaintenanceRepository() MaintenanceRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&MaintenanceRecord{}); err != nil {
+		panic(err)
+	}
+	return &maintenanceRepository{db: db}
+}
+
+func (r *maintenanceRepository) Create(record MaintenanceRecord) (int, error) {
+	err := r.db.Create(&record).Error
+	return record.ID, err
+}
+
+// ListByUser returns the user's records, newest service first.
+func (r *maintenanceRepository) ListByUser(userID string) ([]MaintenanceRecord, error) {
+	var result []MaintenanceRecord
+	return result, r.db.Where("user_id = ?", userID).
+		Order("serviced_at DESC, mileage DESC, id DESC").
+		Find(&result).Error
+}
+
+// Delete removes one of the user's records and reports whether it existed.
+func (r *maintenanceRepository) Delete(userID string, id int) (bool, error) {
+	result := r.db.Where("user_id = ? AND id = ?", userID, id).Delete(&MaintenanceRecord{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListLatestUnreminded returns the latest record of each user's plate, for
+// plates whose next service has not been reminded yet.
+func (r *maintenanceRepository) ListLatestUnreminded() ([]MaintenanceRecord, error) {
+	var result []MaintenanceRecord
+	return result, r.db.Raw(`SELECT * FROM (
+		SELECT DISTINCT ON (user_id, license_plate_number) * FROM maintenance_records
+		ORDER BY user_id, license_plate_number, serviced_at DESC, mileage DESC, id DESC
+	) latest WHERE reminded_at IS NULL`).Scan(&result).Error
+}
+
+func (r *maintenanceRepository) MarkReminded(id int) error {
+	return r.db.Model(&MaintenanceRecord{}).Where("id = ?", id).Update("reminded_at", time.Now()).Error
+}
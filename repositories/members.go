@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Member struct {
+	ID       int
+	GroupID  string `gorm:"uniqueIndex:idx_members_group_user"`
+	UserID   string `gorm:"uniqueIndex:idx_members_group_user"`
+	JoinedAt time.Time
+	LeftAt   *time.Time
+}
+
+type MembersRepository interface {
+	Join(groupID, userID string) error
+	Leave(groupID, userID string) error
+	ListActive(groupID string) ([]Member, error)
+}
+
+type memberRepository struct {
+	db *gorm.DB
+}
+
+func NewMemberRepository() MembersRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&Member{}); err != nil {
+		panic(err)
+	}
+	return &memberRepository{db: db}
+}
+
+func (r *memberRepository) Join(groupID, userID string) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "group_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"joined_at": time.Now(), "left_at": nil}),
+	}).Create(&Member{GroupID: groupID, UserID: userID, JoinedAt: time.Now()}).Error
+}
+
+func (r *memberRepository) Leave(groupID, userID string) error {
+	now := time.Now()
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "group_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"left_at": now}),
+	}).Create(&Member{GroupID: groupID, UserID: userID, LeftAt: &now}).Error
+}
+
+func (r *memberRepository) ListActive(groupID string) ([]Member, error) {
+	var result []Member
+	return result, r.db.Where("group_id = ? AND left_at IS NULL", groupID).Order("joined_at").Find(&result).Error
+}
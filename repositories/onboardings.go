@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Onboarding struct {
+	ID          int
+	UserID      string `gorm:"uniqueIndex"`
+	CompletedAt time.Time
+}
+
+type OnboardingsRepository interface {
+	Complete(userID string) error
+	IsCompleted(userID string) (bool, error)
+	FilterCompleted(userIDs []string) (map[string]bool, error)
+}
+
+type onboardingRepository struct {
+	db *gorm.DB
+}
+
+func NewOnboardingRepository() OnboardingsRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&Onboarding{}); err != nil {
+		panic(err)
+	}
+	return &onboardingRepository{db: db}
+}
+
+func (r *onboardingRepository) Complete(userID string) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"completed_at"}),
+	}).Create(&Onboarding{UserID: userID, CompletedAt: time.Now()}).Error
+}
+
+func (r *onboardingRepository) IsCompleted(userID string) (bool, error) {
+	var count int64
+	return count > 0, r.db.Model(&Onboarding{}).Where("user_id = ?", userID).Count(&count).Error
+}
+
+func (r *onboardingRepository) FilterCompleted(userIDs []string) (map[string]bool, error) {
+	result := map[string]bool{}
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+	var rows []Onboarding
+	if err := r.db.Where("user_id IN ?", userIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.UserID] = true
+	}
+	return result, nil
+}
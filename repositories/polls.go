@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Poll struct {
+	ID        int
+	GroupID   string `gorm:"index"`
+	Question  string
+	CreatorID string
+	Options   []PollOption
+	ClosesAt  *time.Time
+	ClosedAt  *time.Time
+	CreatedAt time.Time
+}
+
+type PollOption struct {
+	ID       int
+	PollID   int `gorm:"index"`
+	Position int
+	Text     string
+}
+
+type PollVote struct {
+	ID        int
+	PollID    int    `gorm:"uniqueIndex:idx_poll_votes_poll_user"`
+	UserID    string `gorm:"uniqueIndex:idx_poll_votes_poll_user"`
+	UserName  string
+	Position  int
+	UpdatedAt time.Time
+}
+
+// Open reports whether the poll still takes votes.
+func (p Poll) Open() bool {
+	return p.ClosedAt == nil && (p.ClosesAt == nil || p.ClosesAt.After(time.Now()))
+}
+
+type PollsRepository interface {
+	Create(poll Poll) (int, error)
+	Get(id int) (Poll, error)
+	ListOpen(groupID string) ([]Poll, error)
+	Vote(vote PollVote) error
+	ListVotes(pollID int) ([]PollVote, error)
+	Close(id int) (bool, error)
+	ListDueToClose() ([]Poll, error)
+}
+
+type pollRepository struct {
+	db *gorm.DB
+}
+
+func NewPollRepository() PollsRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&Poll{}, &PollOption{}, &PollVote{}); err != nil {
+		panic(err)
+	}
+	return &pollRepository{db: db}
+}
+
+// Create saves the poll together with its options.
+func (r *pollRepository) Create(poll Poll) (int, error) {
+	err := r.db.Create(&poll).Error
+	return poll.ID, err
+}
+
+func (r *pollRepository) Get(id int) (Poll, error) {
+	var poll Poll
+	return poll, r.db.Preload("Options", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).First(&poll, id).Error
+}
+
+func (r *pollRepository) ListOpen(groupID string) ([]Poll, error) {
+	var result []Poll
+	return result, r.db.Preload("Options", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).
+		Where("group_id = ? AND closed_at IS NULL AND (closes_at IS NULL OR closes_at > ?)", groupID, time.Now()).
+		Order("id").
+		Find(&result).Error
+}
+
+// Vote records the user's choice, replacing an earlier one.
+func (r *pollRepository) Vote(vote PollVote) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "poll_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_name", "position", "updated_at"}),
+	}).Create(&vote).Error
+}
+
+func (r *pollRepository) ListVotes(pollID int) ([]PollVote, error) {
+	var result []PollVote
+	return result, r.db.Where("poll_id = ?", pollID).Order("updated_at, id").Find(&result).Error
+}
+
+// Close marks the poll closed and reports whether it was still open, so
+// results are announced only once.
+func (r *pollRepository) Close(id int) (bool, error) {
+	result := r.db.Model(&Poll{}).Where("id = ? AND closed_at IS NULL", id).Update("closed_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListDueToClose returns polls whose close time has passed but which have
+// not been closed yet.
+func (r *pollRepository) ListDueToClose() ([]Poll, error) {
+	var result []Poll
+	return result, r.db.Preload("Options", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).
+		Where("closed_at IS NULL AND closes_at <= ?", time.Now()).
+		Find(&result).Error
+}
@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Role string
+
+const (
+	RoleGuest    Role = "guest"
+	RoleMember   Role = "member"
+	RoleVerified Role = "verified"
+	RoleAdmin    Role = "admin"
+	RoleOwner    Role = "owner"
+)
+
+var roleLevels = map[Role]int{
+	RoleGuest:    0,
+	RoleMember:   1,
+	RoleVerified: 2,
+	RoleAdmin:    3,
+	RoleOwner:    4,
+}
+
+// Level orders roles from guest (0) to owner; unknown roles rank as guest.
+func (r Role) Level() int {
+	return roleLevels[r]
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleLevels[r]
+	return ok
+}
+
+type UserRole struct {
+	ID        int
+	UserID    string `gorm:"uniqueIndex"`
+	Role      Role
+	UpdatedAt time.Time
+}
+
+type RolesRepository interface {
+	Get(userID string) (Role, error)
+	Set(userID string, role Role) error
+	ListByRole(role Role) ([]UserRole, error)
+}
+
+type roleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository() RolesRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&UserRole{}); err != nil {
+		panic(err)
+	}
+	return &roleRepository{db: db}
+}
+
+func (r *roleRepository) Get(userID string) (Role, error) {
+	var userRole UserRole
+	return userRole.Role, r.db.Where("user_id = ?", userID).First(&userRole).Error
+}
+
+func (r *roleRepository) Set(userID string, role Role) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "updated_at"}),
+	}).Create(&UserRole{UserID: userID, Role: role}).Error
+}
+
+func (r *roleRepository) ListByRole(role Role) ([]UserRole, error) {
+	var result []UserRole
+	return result, r.db.Where("role = ?", role).Order("updated_at").Find(&result).Error
+}
@@ -0,0 +1,88 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type VerificationStatus string
+
+const (
+	VerificationStatusPending  VerificationStatus = "pending"
+	VerificationStatusApproved VerificationStatus = "approved"
+	VerificationStatusRejected VerificationStatus = "rejected"
+)
+
+type Verification struct {
+	ID                 int
+	UserID             string `gorm:"index"`
+	UserName           string
+	LicensePlateNumber string
+	PhotoURL           string
+	Status             VerificationStatus
+	ReviewerID         string
+	ReviewedAt         *time.Time
+	CreatedAt          time.Time
+}
+
+type VerificationsRepository interface {
+	Create(verification Verification) (int, error)
+	Get(id int) (Verification, error)
+	Review(id int, status VerificationStatus, reviewerID string) error
+	IsVerified(userID string) (bool, error)
+	ListApproved(userIDs []string) ([]Verification, error)
+}
+
+type verificationRepository struct {
+	db *gorm.DB
+}
+
+func NewVerificationRepository() VerificationsRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&Verification{}); err != nil {
+		panic(err)
+	}
+	return &verificationRepository{db: db}
+}
+
+func (r *verificationRepository) Create(verification Verification) (int, error) {
+	verification.Status = VerificationStatusPending
+	err := r.db.Create(&verification).Error
+	return verification.ID, err
+}
+
+func (r *verificationRepository) Get(id int) (Verification, error) {
+	var verification Verification
+	return verification, r.db.First(&verification, id).Error
+}
+
+// Review decides a pending verification. It returns ErrNotFound when the
+// verification does not exist or has already been reviewed.
+func (r *verificationRepository) Review(id int, status VerificationStatus, reviewerID string) error {
+	result := r.db.Model(&Verification{}).
+		Where("id = ? AND status = ?", id, VerificationStatusPending).
+		Updates(map[string]interface{}{"status": status, "reviewer_id": reviewerID, "reviewed_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *verificationRepository) IsVerified(userID string) (bool, error) {
+	var count int64
+	return count > 0, r.db.Model(&Verification{}).
+		Where("user_id = ? AND status = ?", userID, VerificationStatusApproved).
+		Count(&count).Error
+}
+
+func (r *verificationRepository) ListApproved(userIDs []string) ([]Verification, error) {
+	var result []Verification
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+	return result, r.db.Where("user_id IN ? AND status = ?", userIDs, VerificationStatusApproved).Find(&result).Error
+}
@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WelcomeTemplate struct {
+	ID        int
+	GroupID   string `gorm:"uniqueIndex"`
+	Text      string
+	UpdatedAt time.Time
+}
+
+type WelcomeCard struct {
+	ID         int
+	GroupID    string `gorm:"index"`
+	Position   int
+	ImageURL   string
+	ButtonText string
+	URL        string
+}
+
+// WelcomeCardSet marks a group that has its own card list, so deleting its
+// last card leaves it with no cards instead of the defaults.
+type WelcomeCardSet struct {
+	ID        int
+	GroupID   string `gorm:"uniqueIndex"`
+	UpdatedAt time.Time
+}
+
+type WelcomesRepository interface {
+	GetTemplate(groupID string) (WelcomeTemplate, error)
+	SetTemplate(groupID, text string) error
+	DeleteTemplate(groupID string) error
+	ListCards(groupID string) ([]WelcomeCard, bool, error)
+	AddCard(card WelcomeCard) error
+	DeleteCard(groupID string, position int) error
+	ClearCards(groupID string) error
+}
+
+type welcomeRepository struct {
+	db *gorm.DB
+}
+
+func NewWelcomeRepository() WelcomesRepository {
+	db := openDB()
+	if err := db.AutoMigrate(&WelcomeTemplate{}, &WelcomeCard{}, &WelcomeCardSet{}); err != nil {
+		panic(err)
+	}
+	return &welcomeRepository{db: db}
+}
+
+func (r *welcomeRepository) GetTemplate(groupID string) (WelcomeTemplate, error) {
+	var template WelcomeTemplate
+	return template, r.db.Where("group_id = ?", groupID).First(&template).Error
+}
+
+func (r *welcomeRepository) SetTemplate(groupID, text string) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "group_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"text", "updated_at"}),
+	}).Create(&WelcomeTemplate{GroupID: groupID, Text: text}).Error
+}
+
+func (r *welcomeRepository) DeleteTemplate(groupID string) error {
+	return r.db.Where("group_id = ?", groupID).Delete(&WelcomeTemplate{}).Error
+}
+
+// ListCards returns the group's cards and whether the group has its own
+// card list, which may be empty.
+func (r *welcomeRepository) ListCards(groupID string) ([]WelcomeCard, bool, error) {
+	var result []WelcomeCard
+	if err := r.db.Where("group_id = ?", groupID).Order("position, id").Find(&result).Error; err != nil {
+		return nil, false, err
+	}
+	if len(result) > 0 {
+		return result, true, nil
+	}
+	var count int64
+	err := r.db.Model(&WelcomeCardSet{}).Where("group_id = ?", groupID).Count(&count).Error
+	return result, count > 0, err
+}
+
+func markCardSet(tx *gorm.DB, groupID string) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "group_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
+	}).Create(&WelcomeCardSet{GroupID: groupID}).Error
+}
+
+// AddCard appends the card after the group's existing cards.
+func (r *welcomeRepository) AddCard(card WelcomeCard) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := markCardSet(tx, card.GroupID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&WelcomeCard{}).Where("group_id = ?", card.GroupID).Count(&count).Error; err != nil {
+			return err
+		}
+		card.Position = int(count) + 1
+		return tx.Create(&card).Error
+	})
+}
+
+// DeleteCard removes the card at the 1-based position and closes the gap.
+func (r *welcomeRepository) DeleteCard(groupID string, position int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("group_id = ? AND position = ?", groupID, position).Delete(&WelcomeCard{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if err := markCardSet(tx, groupID); err != nil {
+			return err
+		}
+		return tx.Model(&WelcomeCard{}).
+			Where("group_id = ? AND position > ?", groupID, position).
+			Update("position", gorm.Expr("position - 1")).Error
+	})
+}
+
+// ClearCards removes the group's own card list, so it gets the defaults
+// again.
+func (r *welcomeRepository) ClearCards(groupID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", groupID).Delete(&WelcomeCard{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("group_id = ?", groupID).Delete(&WelcomeCardSet{}).Error
+	})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const (
+	defaultRichMenuConfig = "richmenus/richmenus.json"
+	richMenuAliasPrefix   = "kamiq-"
+
+	richMenuUnregistered = "unregistered"
+	richMenuRegistered   = "registered"
+	richMenuAdmin        = "admin"
+)
+
+type RichMenuConfig struct {
+	Menus []RichMenuDefinition `json:"menus"`
+}
+
+// RichMenuDefinition describes one menu. Image is relative to the image
+// directory (see richMenuImageDir) and must be a 2500px wide PNG or JPEG matching Size.
+type RichMenuDefinition struct {
+	Name        string               `json:"name"`
+	ChatBarText string               `json:"chatBarText"`
+	Image       string               `json:"image"`
+	Size        linebot.RichMenuSize `json:"size"`
+	Areas       []linebot.AreaDetail `json:"areas"`
+}
+
+var richMenuIDs = sync.Map{}
+
+func richMenuConfigPath() string {
+	if path := os.Getenv("RICHMENU_CONFIG"); path != "" {
+		return path
+	}
+	return defaultRichMenuConfig
+}
+
+// richMenuImageDir is where the menu images are read from: RICHMENU_IMAGES
+// if set, otherwise the directory of the config file.
+func richMenuImageDir(configPath string) string {
+	if dir := os.Getenv("RICHMENU_IMAGES"); dir != "" {
+		return dir
+	}
+	return filepath.Dir(configPath)
+}
+
+func loadRichMenuConfig(path string) (RichMenuConfig, error) {
+	var config RichMenuConfig
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	return config, json.Unmarshal(data, &config)
+}
+
+// syncRichMenus uploads every menu in the config, points its alias at the
+// new menu, deletes the menus it replaced and makes the unregistered menu
+// the default.
+func syncRichMenus(path string) error {
+	config, err := loadRichMenuConfig(path)
+	if err != nil {
+		return err
+	}
+
+	// The menu images are not kept in the repository, so check them all
+	// before anything is uploaded rather than failing half way through.
+	imageDir := richMenuImageDir(path)
+	var missing []string
+	for _, def := range config.Menus {
+		if _, err := os.Stat(filepath.Join(imageDir, def.Image)); err != nil {
+			missing = append(missing, def.Image)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("rich menu images missing in %s: %s (2500px wide PNG or JPEG, set RICHMENU_IMAGES, see README)",
+			imageDir, strings.Join(missing, ", "))
+	}
+
+	existing, err := bot.GetRichMenuList().Do()
+	if err != nil {
+		return err
+	}
+
+	for _, def := range config.Menus {
+		image := filepath.Join(imageDir, def.Image)
+
+		resp, err := bot.CreateRichMenu(linebot.RichMenu{
+			Size:        def.Size,
+			Selected:    false,
+			Name:        def.Name,
+			ChatBarText: def.ChatBarText,
+			Areas:       def.Areas,
+		}).Do()
+		if err != nil {
+			return fmt.Errorf("rich menu %s: %w", def.Name, err)
+		}
+		if _, err := bot.UploadRichMenuImage(resp.RichMenuID, image).Do(); err != nil {
+			return fmt.Errorf("rich menu %s: %w", def.Name, err)
+		}
+
+		aliasID := richMenuAliasPrefix + def.Name
+		if _, err := bot.UpdateRichMenuAlias(aliasID, resp.RichMenuID).Do(); err != nil {
+			if _, err := bot.CreateRichMenuAlias(aliasID, resp.RichMenuID).Do(); err != nil {
+				return fmt.Errorf("rich menu %s: %w", def.Name, err)
+			}
+		}
+		log.Printf("rich menu %s synced as %s", def.Name, resp.RichMenuID)
+
+		if def.Name == richMenuUnregistered {
+			if _, err := bot.SetDefaultRichMenu(resp.RichMenuID).Do(); err != nil {
+				return err
+			}
+		}
+
+		for _, old := range existing {
+			if old.Name == def.Name && old.RichMenuID != resp.RichMenuID {
+				if _, err := bot.DeleteRichMenu(old.RichMenuID).Do(); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// richMenuID resolves a menu's alias. The result is cached, but "richmenu
+// sync" runs in another process and deletes replaced menus, so linking
+// drops the entry and resolves again when the cached menu is gone.
+func richMenuID(name string) (string, error) {
+	if id, ok := richMenuIDs.Load(name); ok {
+		return id.(string), nil
+	}
+	alias, err := bot.GetRichMenuAlias(richMenuAliasPrefix + name).Do()
+	if err != nil {
+		return "", err
+	}
+	richMenuIDs.Store(name, alias.RichMenuID)
+	return alias.RichMenuID, nil
+}
+
+// linkRichMenu gives the user the menu matching their current state.
+// Unregistered users fall back to the default menu.
+func linkRichMenu(userID string) {
+	name := richMenuUnregistered
+	if hasRole(userID, "", repositories.RoleAdmin) {
+		name = richMenuAdmin
+	} else if rows, err := catcherRepo.ListByUser(userID); err != nil {
+		log.Println(err)
+		return
+	} else if len(rows) > 0 {
+		name = richMenuRegistered
+	}
+
+	if name == richMenuUnregistered {
+		if _, err := bot.UnlinkUserRichMenu(userID).Do(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	id, err := richMenuID(name)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	_, err = bot.LinkUserRichMenu(userID, id).Do()
+	var apiErr *linebot.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		richMenuIDs.Delete(name)
+		if id, err = richMenuID(name); err != nil {
+			log.Println(err)
+			return
+		}
+		_, err = bot.LinkUserRichMenu(userID, id).Do()
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// handleMenuPostback answers the rich menu, which only 1:1 chats have.
+func handleMenuPostback(event *linebot.Event, values url.Values) {
+	userID := event.Source.UserID
+	lang := i18n.Default
+
+	switch values.Get("item") {
+	case "profile":
+		rows, err := catcherRepo.ListByUser(userID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if len(rows) == 0 {
+			replyText(event.ReplyToken, i18n.T(lang, "menu.unregistered"))
+			return
+		}
+		replyCarousel(event.ReplyToken, "", i18n.T(lang, "menu.profile"), makeCatcherContents(lang, rows))
+	case "search":
+		replyText(event.ReplyToken, i18n.T(lang, "menu.search"))
+	case "leaderboard":
+		wild, err := catcherRepo.TopWildCatchers(10)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		lines := []string{i18n.T(lang, "menu.leaderboard")}
+		for idx, catcher := range wild {
+			lines = append(lines, i18n.T(lang, "menu.leaderboard_line", idx+1, catcher.LicensePlateNumber, catcher.Count))
+		}
+		replyText(event.ReplyToken, strings.Join(lines, "\n"))
+	case "faq":
+		if cmd, _ := findCommand("指令"); cmd != nil {
+			cmd.Handler(&commandContext{Event: event, UserID: userID, Text: "常用指令", Msg: "指令", Lang: lang})
+		}
+	case "admin":
+		if !hasRole(userID, "", repositories.RoleAdmin) {
+			replyText(event.ReplyToken, permissionDenied(lang, repositories.RoleAdmin))
+			return
+		}
+		replyText(event.ReplyToken, i18n.T(lang, "menu.admin"))
+	}
+}
+
+// runRichMenuCommand implements "kamiq-bot richmenu sync [config]".
+func runRichMenuCommand(args []string) error {
+	if len(args) == 0 || args[0] != "sync" {
+		return errors.New("usage: kamiq-bot richmenu sync [config]")
+	}
+	path := richMenuConfigPath()
+	if len(args) > 1 {
+		path = args[1]
+	}
+	return syncRichMenus(path)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/conversation"
+	"github.com/tzuhsitseng/kamiq-bot/flex"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+const (
+	verificationFlowName = "verification"
+	verificationPlateKey = "plate"
+	verificationPhotoKey = "photo"
+)
+
+var verificationRepo repositories.VerificationsRepository
+
+func verificationFlow() *conversation.Flow {
+	lang := i18n.Default
+	return &conversation.Flow{
+		Name: verificationFlowName,
+		Lang: lang,
+		Steps: []conversation.Step{
+			{
+				Key:      verificationPlateKey,
+				Prompt:   i18n.T(lang, "verify.plate_prompt"),
+				Validate: validateLicensePlateNumber,
+			},
+			{
+				Key:    verificationPhotoKey,
+				Prompt: i18n.T(lang, "verify.photo_prompt"),
+				Input:  conversation.InputImage,
+				QuickReplies: []*linebot.QuickReplyButton{
+					linebot.NewQuickReplyButton("", linebot.NewCameraRollAction(i18n.T(lang, "catcher.choose_photo"))),
+					linebot.NewQuickReplyButton("", linebot.NewCameraAction(i18n.T(lang, "catcher.take_photo"))),
+				},
+			},
+		},
+		Complete:   submitVerification,
+		CancelText: i18n.T(lang, "verify.cancelled"),
+	}
+}
+
+// submitVerification stores the application and sends it to every admin
+// group for review.
+func submitVerification(replyToken string, s *conversation.Session) {
+	userName := ""
+	if profile, err := bot.GetProfile(s.UserID).Do(); err == nil {
+		userName = profile.DisplayName
+	} else {
+		log.Println(err)
+	}
+
+	verification := repositories.Verification{
+		UserID:             s.UserID,
+		UserName:           userName,
+		LicensePlateNumber: s.Get(verificationPlateKey),
+		PhotoURL:           s.Get(verificationPhotoKey),
+	}
+	id, err := verificationRepo.Create(verification)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	verification.ID = id
+
+	replyText(replyToken, i18n.T(i18n.Default, "verify.submitted"))
+
+	adminGroups, err := groupRepo.ListByType(repositories.GroupTypeAdmin)
+	if err != nil {
+		log.Println(err)
+	}
+	// Review cards stay Flex even in text-only groups: the decision is made
+	// with their postback buttons.
+	for _, group := range adminGroups {
+		lang := languageOf(group.GroupID)
+		if _, err := bot.PushMessage(group.GroupID, linebot.NewFlexMessage(
+			i18n.T(lang, "verify.alt", verification.LicensePlateNumber, verification.UserName),
+			makeVerificationCard(lang, verification),
+		)).Do(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func makeVerificationCard(lang string, verification repositories.Verification) *linebot.BubbleContainer {
+	approve := fmt.Sprintf("action=verify&id=%d&decision=%s", verification.ID, repositories.VerificationStatusApproved)
+	reject := fmt.Sprintf("action=verify&id=%d&decision=%s", verification.ID, repositories.VerificationStatusRejected)
+	hero := flex.Hero(verification.PhotoURL, linebot.FlexImageAspectModeTypeCover)
+	hero.Action = linebot.NewURIAction(i18n.T(lang, "verify.view_photo"), verification.PhotoURL)
+	return flex.Bubble(hero,
+		flex.Box(
+			flex.Title(i18n.T(lang, "verify.title")),
+			flex.Text(fmt.Sprintf("%s: %s", i18n.T(lang, "card.plate"), verification.LicensePlateNumber)),
+			flex.Text(fmt.Sprintf("%s: %s", i18n.T(lang, "card.name"), verification.UserName)),
+		),
+		flex.Buttons(
+			linebot.NewPostbackAction(i18n.T(lang, "verify.approve"), approve, "", ""),
+			linebot.NewPostbackAction(i18n.T(lang, "verify.reject"), reject, "", ""),
+		),
+	)
+}
+
+// handleVerifyPostback records an admin's decision. Only postbacks from
+// admin groups are honoured, since that is where the review cards live.
+func handleVerifyPostback(event *linebot.Event, values url.Values) {
+	groupID := event.Source.GroupID
+	if groupID == "" || !isAdminGroup(groupID) {
+		return
+	}
+	lang := languageOf(groupID)
+	if !hasRole(event.Source.UserID, groupID, repositories.RoleAdmin) {
+		replyText(event.ReplyToken, permissionDenied(lang, repositories.RoleAdmin))
+		return
+	}
+
+	id, err := strconv.Atoi(values.Get("id"))
+	if err != nil {
+		return
+	}
+	status := repositories.VerificationStatus(values.Get("decision"))
+	if status != repositories.VerificationStatusApproved && status != repositories.VerificationStatusRejected {
+		return
+	}
+
+	if err := verificationRepo.Review(id, status, event.Source.UserID); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			replyText(event.ReplyToken, i18n.T(lang, "verify.reviewed"))
+		} else {
+			log.Println(err)
+		}
+		return
+	}
+
+	verification, err := verificationRepo.Get(id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	reviewer := event.Source.UserID
+	if profile, err := groupMemberProfile(groupID, event.Source.UserID); err == nil {
+		reviewer = profile.DisplayName
+	}
+
+	result, notice := "verify.rejected", i18n.T(i18n.Default, "verify.rejected_notice")
+	if status == repositories.VerificationStatusApproved {
+		result, notice = "verify.approved", i18n.T(i18n.Default, "verify.approved_notice", verification.LicensePlateNumber)
+	}
+	replyText(event.ReplyToken, i18n.T(lang, result, reviewer, verification.UserName, verification.LicensePlateNumber))
+	if _, err := bot.PushMessage(verification.UserID, linebot.NewTextMessage(notice)).Do(); err != nil {
+		log.Println(err)
+	}
+}
+
+// verifiedPlates returns the approved user+plate pairs among catchers, keyed
+// by verifiedKey.
+func verifiedPlates(catchers []repositories.Catcher) map[string]bool {
+	userIDs := make([]string, 0, len(catchers))
+	for _, catcher := range catchers {
+		userIDs = append(userIDs, catcher.UserID)
+	}
+	verifications, err := verificationRepo.ListApproved(userIDs)
+	if err != nil {
+		log.Println(err)
+	}
+	result := map[string]bool{}
+	for _, verification := range verifications {
+		result[verifiedKey(verification.UserID, verification.LicensePlateNumber)] = true
+	}
+	return result
+}
+
+func verifiedKey(userID, licensePlateNumber string) string {
+	return userID + "|" + licensePlateNumber
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"github.com/tzuhsitseng/kamiq-bot/flex"
+	"github.com/tzuhsitseng/kamiq-bot/i18n"
+	"github.com/tzuhsitseng/kamiq-bot/repositories"
+)
+
+var welcomeRepo repositories.WelcomesRepository
+
+var defaultWelcomeCards = []repositories.WelcomeCard{
+	{
+		ImageURL:   "https://kamiq.club/upload/36/news_images/6b8a6da0-cafb-4904-87b7-d9ffa01b2075.jpeg",
+		ButtonText: "入群必讀",
+		URL:        rulesURL,
+	},
+	{
+		ImageURL:   "https://i.imgur.com/Jo0JBxU.png",
+		ButtonText: "KamiQ車友群官網",
+		URL:        "https://kamiq.club",
+	},
+	{
+		ImageURL:   "https://i.imgur.com/rILuNbA.jpg",
+		ButtonText: "一起抓抓樂",
+		URL:        "https://lin.ee/e6uqqPo",
+	},
+}
+
+func welcome(replyToken, groupID, names string) {
+	if _, err := bot.ReplyMessage(replyToken, welcomeMessages(groupID, names)...).Do(); err != nil {
+		log.Print(err)
+	}
+}
+
+func welcomeMessages(groupID, names string) []linebot.SendingMessage {
+	messages := []linebot.SendingMessage{linebot.NewTextMessage(welcomeText(groupID, names))}
+	if cards := welcomeCards(groupID); len(cards) > 0 {
+		carousels, _ := flex.Messages("", makeInfoCard(cards), flex.MaxMessages-len(messages), isTextOnly(groupID))
+		messages = append(messages, carousels...)
+	}
+	return messages
+}
+
+// welcomeTemplate is the group's own template, or the catalog's default in
+// the group's language. {names} is replaced with the new members' names and
+// {group} with the group's registered name.
+func welcomeTemplate(groupID string) string {
+	template, err := welcomeRepo.GetTemplate(groupID)
+	if err != nil {
+		if !errors.Is(err, repositories.ErrNotFound) {
+			log.Println(err)
+		}
+		return i18n.T(languageOf(groupID), "welcome.text")
+	}
+	return template.Text
+}
+
+func welcomeText(groupID, names string) string {
+	text := welcomeTemplate(groupID)
+	groupName := ""
+	if group, err := groupRepo.Get(groupID); err == nil {
+		groupName = group.Name
+	}
+	if names != "" {
+		names = fmt.Sprintf(" %s ", names)
+	}
+	return strings.NewReplacer("{names}", names, "{group}", groupName).Replace(text)
+}
+
+// welcomeCards is the group's own card list, which may be empty, or the
+// defaults if the group never set one up.
+func welcomeCards(groupID string) []repositories.WelcomeCard {
+	cards, configured, err := welcomeRepo.ListCards(groupID)
+	if err != nil {
+		log.Println(err)
+	}
+	if !configured {
+		return defaultWelcomeCards
+	}
+	return cards
+}
+
+// isHTTPSURL reports whether text is an absolute https URL, which is all
+// LINE accepts for images and links in cards.
+func isHTTPSURL(text string) bool {
+	u, err := url.ParseRequestURI(text)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+func makeInfoCard(cards []repositories.WelcomeCard) []flex.Card {
+	contents := make([]flex.Card, 0, len(cards))
+	for _, card := range cards {
+		contents = append(contents, flex.Card{
+			Bubble: flex.ButtonCard(
+				card.ImageURL,
+				linebot.FlexImageAspectModeTypeCover,
+				linebot.NewURIAction(card.ButtonText, card.URL),
+			),
+			Alt: card.ButtonText,
+		})
+	}
+	return contents
+}
+
+// welcomeAdminCommand lets admins edit the group's welcome template and
+// info cards. "?test welcome" previews the result.
+func welcomeAdminCommand(ctx *commandContext) {
+	replyToken, groupID, msg, lang := ctx.Event.ReplyToken, ctx.GroupID, ctx.Msg, ctx.Lang
+	fields := strings.Fields(msg)
+
+	sub := ""
+	if len(fields) > 1 {
+		sub = fields[1]
+	}
+
+	if fields[0] == "歡迎詞" {
+		switch sub {
+		case "":
+			replyText(replyToken, i18n.T(lang, "welcome.current", welcomeTemplate(groupID)))
+		case "設定":
+			text := strings.TrimSpace(msg[strings.Index(msg, "設定")+len("設定"):])
+			if text == "" {
+				replyText(replyToken, i18n.T(lang, "welcome.set_usage"))
+				return
+			}
+			if err := welcomeRepo.SetTemplate(groupID, text); err != nil {
+				log.Println(err)
+				return
+			}
+			replyText(replyToken, i18n.T(lang, "welcome.updated"))
+		case "重設":
+			if err := welcomeRepo.DeleteTemplate(groupID); err != nil {
+				log.Println(err)
+				return
+			}
+			replyText(replyToken, i18n.T(lang, "welcome.reset"))
+		default:
+			replyText(replyToken, i18n.T(lang, "welcome.usage"))
+		}
+		return
+	}
+
+	// Editing starts from what the group currently sees, so the first edit
+	// copies the default cards into the group.
+	if sub == "新增" || sub == "刪除" {
+		if _, configured, err := welcomeRepo.ListCards(groupID); err != nil {
+			log.Println(err)
+			return
+		} else if !configured {
+			for _, card := range defaultWelcomeCards {
+				card.GroupID = groupID
+				if err := welcomeRepo.AddCard(card); err != nil {
+					log.Println(err)
+					return
+				}
+			}
+		}
+	}
+
+	switch sub {
+	case "":
+		cards := welcomeCards(groupID)
+		if len(cards) == 0 {
+			replyText(replyToken, i18n.T(lang, "welcome.card_none"))
+			return
+		}
+		lines := make([]string, 0, len(cards))
+		for idx, card := range cards {
+			lines = append(lines, fmt.Sprintf("%d. %s\n%s\n%s", idx+1, card.ButtonText, card.URL, card.ImageURL))
+		}
+		replyText(replyToken, strings.Join(lines, "\n\n"))
+	case "新增":
+		if len(fields) < 5 {
+			replyText(replyToken, i18n.T(lang, "welcome.card_add_usage"))
+			return
+		}
+		for _, link := range fields[2:4] {
+			if !isHTTPSURL(link) {
+				replyText(replyToken, i18n.T(lang, "welcome.card_invalid_url", link))
+				return
+			}
+		}
+		card := repositories.WelcomeCard{
+			GroupID:    groupID,
+			ImageURL:   fields[2],
+			URL:        fields[3],
+			ButtonText: strings.Join(fields[4:], " "),
+		}
+		if err := welcomeRepo.AddCard(card); err != nil {
+			log.Println(err)
+			return
+		}
+		replyText(replyToken, i18n.T(lang, "welcome.card_added"))
+	case "刪除":
+		position := 0
+		if len(fields) > 2 {
+			position, _ = strconv.Atoi(fields[2])
+		}
+		if err := welcomeRepo.DeleteCard(groupID, position); err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				replyText(replyToken, i18n.T(lang, "welcome.card_not_found"))
+			} else {
+				log.Println(err)
+			}
+			return
+		}
+		replyText(replyToken, i18n.T(lang, "welcome.card_deleted"))
+	case "重設":
+		if err := welcomeRepo.ClearCards(groupID); err != nil {
+			log.Println(err)
+			return
+		}
+		replyText(replyToken, i18n.T(lang, "welcome.card_reset"))
+	default:
+		replyText(replyToken, i18n.T(lang, "welcome.card_usage"))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWelcomeWindow = 10 * time.Second
+	// replyTokenTTL is kept below LINE's actual reply token lifetime so a
+	// reply is never attempted with a token that is about to expire.
+	replyTokenTTL = 50 * time.Second
+)
+
+var welcomes *welcomeBatcher
+
+type pendingWelcome struct {
+	replyToken string
+	receivedAt time.Time
+	names      []string
+}
+
+// welcomeBatcher collects memberJoined events per group for the window
+// following the first join and then sends one combined welcome.
+type welcomeBatcher struct {
+	mu      sync.Mutex
+	window  time.Duration
+	pending map[string]*pendingWelcome
+}
+
+func newWelcomeBatcher() *welcomeBatcher {
+	window := defaultWelcomeWindow
+	if v, err := time.ParseDuration(os.Getenv("WELCOME_DEBOUNCE")); err == nil && v >= 0 {
+		window = v
+	}
+	return &welcomeBatcher{
+		window:  window,
+		pending: map[string]*pendingWelcome{},
+	}
+}
+
+func (b *welcomeBatcher) Add(groupID, replyToken string, names []string) {
+	if b.window == 0 {
+		sendWelcome(groupID, replyToken, time.Now(), names)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, ok := b.pending[groupID]
+	if !ok {
+		p = &pendingWelcome{}
+		b.pending[groupID] = p
+		time.AfterFunc(b.window, func() { b.flush(groupID) })
+	}
+	// The newest token is the one most likely to still be valid.
+	p.replyToken = replyToken
+	p.receivedAt = time.Now()
+	p.names = append(p.names, names...)
+}
+
+func (b *welcomeBatcher) flush(groupID string) {
+	b.mu.Lock()
+	p, ok := b.pending[groupID]
+	delete(b.pending, groupID)
+	b.mu.Unlock()
+
+	if ok {
+		sendWelcome(groupID, p.replyToken, p.receivedAt, p.names)
+	}
+}
+
+// sendWelcome replies while the token is fresh and falls back to a push
+// message when it has expired or the reply is rejected.
+func sendWelcome(groupID, replyToken string, receivedAt time.Time, names []string) {
+	messages := welcomeMessages(groupID, strings.Join(names, ","))
+	if time.Since(receivedAt) < replyTokenTTL {
+		_, err := bot.ReplyMessage(replyToken, messages...).Do()
+		if err == nil {
+			return
+		}
+		log.Println(err)
+	}
+	if _, err := bot.PushMessage(groupID, messages...).Do(); err != nil {
+		log.Println(err)
+	}
+}